@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/auth"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/repository/sqlite"
+)
+
+const clientsUsage = "usage: clients list|issue <name> <scope>...|bind <name> <certificate identity> <scope>...|revoke <id>"
+
+// runClients implements the clients subcommand against the store in DB_URL. It issues
+// the first admin client of a keystore initialized without BOOTSTRAP_SECRET, before API
+// tokens existed or whose root token was lost:
+//
+//	list                               lists every client
+//	issue <name> <scope>...            issues a client and prints its token
+//	bind <name> <identity> <scope>...  issues a client for mTLS client certificates
+//	revoke <id>                        revokes a client
+func runClients(ctx context.Context, dbUrl string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(clientsUsage)
+	}
+	if dbUrl == "" {
+		return errors.New("DB_URL environment variable is not set")
+	}
+
+	var store repository.KeyStore
+	if path := sqlitePath(dbUrl); path != "" {
+		sqliteStore, err := sqlite.Open(path)
+		if err != nil {
+			return err
+		}
+		defer sqliteStore.Close()
+		store = sqliteStore
+	} else {
+		db, err := initDatabase(dbUrl)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if err := migrateUp(ctx, db); err != nil {
+			return err
+		}
+		store = repository.New(db)
+	}
+	authn := auth.NewAuthenticator(store, auth.DefaultTTL)
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		clients, err := store.ListAPIClients(ctx)
+		if err != nil {
+			return err
+		}
+		for _, client := range clients {
+			revoked := "active"
+			if client.Revoked() {
+				revoked = "revoked " + client.RevocationDate.Format(time.RFC3339)
+			}
+			fmt.Printf("%s  %-20s %-30s %-10s %s\n", client.ID, client.Name, repository.EncodeScopes(client.Scopes), revoked, client.CertificateIdentity)
+		}
+		return nil
+	case args[0] == "issue" && len(args) >= 3:
+		client, token, err := authn.Issue(ctx, args[1], parseScopes(args[2:]))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Client: %s\nToken:  %s\n", client.ID, token)
+		return nil
+	case args[0] == "bind" && len(args) >= 4:
+		client, err := authn.IssueCertificateClient(ctx, args[1], args[2], parseScopes(args[3:]))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Client: %s\nIdentity: %s\n", client.ID, client.CertificateIdentity)
+		return nil
+	case args[0] == "revoke" && len(args) == 2:
+		id, err := uuid.Parse(args[1])
+		if err != nil {
+			return err
+		}
+		client, err := authn.Revoke(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("client %s does not exist", id)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Revoked %s (%s)\n", client.ID, client.Name)
+		return nil
+	default:
+		return errors.New(clientsUsage)
+	}
+}
+
+func parseScopes(args []string) []model.Scope {
+	scopes := make([]model.Scope, len(args))
+	for i, scope := range args {
+		scopes[i] = model.Scope(scope)
+	}
+	return scopes
+}
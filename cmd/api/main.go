@@ -1,18 +1,33 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/valu/encrpytion/internal/api"
+	"github.com/valu/encrpytion/internal/auth"
+	"github.com/valu/encrpytion/internal/jobs"
+	"github.com/valu/encrpytion/internal/keycache"
 	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/repository/memory"
+	"github.com/valu/encrpytion/internal/repository/sqlite"
+	"github.com/valu/encrpytion/internal/rotation"
+	"github.com/valu/encrpytion/internal/seal"
+	"github.com/valu/encrpytion/internal/tlsconfig"
+	"github.com/valu/encrpytion/pkg/crypto"
 )
 
 func main() {
@@ -23,28 +38,189 @@ func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	log.Logger = log.With().Caller().Logger()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(context.Background(), os.Getenv("DB_URL"), os.Args[2:]); err != nil {
+			log.Fatal().Err(err).Msg("Migration failed")
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "clients" {
+		if err := runClients(context.Background(), os.Getenv("DB_URL"), os.Args[2:]); err != nil {
+			log.Fatal().Err(err).Msg("Clients command failed")
+		}
+		return
+	}
+
+	// STORE=memory runs without any database for development. Otherwise the scheme of
+	// DB_URL selects the store: sqlite:// or file:// open a SQLite file, anything else is
+	// Postgres. Rewrap jobs read application tables and are only available on Postgres.
+	var store repository.KeyStore
+	var db *repository.DB
 	dbUrl := os.Getenv("DB_URL")
-	if dbUrl == "" {
+	switch storeType := os.Getenv("STORE"); {
+	case storeType == "memory":
+		store = memory.New()
+		log.Warn().Msg("STORE=memory, all keys are lost when the process exits")
+	case storeType != "":
+		log.Fatal().Str("store", storeType).Msg("STORE only accepts memory, other stores are selected by DB_URL")
+	case dbUrl == "":
 		log.Fatal().Msg("DB_URL environment variable is not set")
+	case sqlitePath(dbUrl) != "":
+		sqliteStore, err := sqlite.Open(sqlitePath(dbUrl))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open SQLite keystore")
+		}
+		defer sqliteStore.Close()
+		log.Info().Str("path", sqlitePath(dbUrl)).Msg("Opened the SQLite keystore")
+		store = sqliteStore
+	default:
+		dbInstance, err := initDatabase(dbUrl)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to initialize database")
+		}
+		defer dbInstance.Close()
+		log.Info().Msg("Connected to the DB")
+
+		if err := migrateUp(context.Background(), dbInstance); err != nil {
+			log.Fatal().Err(err).Msg("Failed to apply migrations")
+		}
+
+		db = repository.New(dbInstance)
+		store = db
 	}
 
-	dbInstance, err := initDatabase(dbUrl)
+	// ROOT_KEY is only read to migrate deployments that predate sealing: /v1/sys/init
+	// splits it into key shares instead of generating a new root key.
+	var legacyRootKey []byte
+	if encoded := os.Getenv("ROOT_KEY"); encoded != "" {
+		legacyRootKey, err = crypto.ParseRootKey(encoded)
+		if err != nil {
+			log.Fatal().Err(err).Msg("ROOT_KEY environment variable is not a valid root key")
+		}
+		log.Warn().Msg("ROOT_KEY is set, remove it once the keystore has been initialized")
+	}
+
+	barrier := seal.NewBarrier(store, legacyRootKey, &log.Logger)
+	log.Info().Msg("Keystore is sealed, waiting for key shares on /v1/sys/unseal")
+
+	if db != nil {
+		runner := jobs.NewRunner(db, barrier, &log.Logger)
+		go runner.Run(context.Background())
+	}
+
+	scheduler := rotation.NewScheduler(store, barrier, &log.Logger)
+	go scheduler.Run(context.Background())
+
+	keys, err := newKeyCache(store, barrier)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to initialize database")
+		log.Fatal().Err(err).Msg("Invalid key cache configuration")
+	}
+	if notifier, ok := store.(repository.KeyChangeNotifier); ok {
+		notifier.OnKeyChange(keys.Invalidate)
+	} else {
+		go keys.Listen(context.Background(), dbUrl)
+	}
+
+	// BOOTSTRAP_SECRET guards /v1/sys/init, which then issues the root API client. Without
+	// it the first client is issued with the clients subcommand.
+	bootstrapSecret := os.Getenv("BOOTSTRAP_SECRET")
+	switch {
+	case bootstrapSecret != "" && len(bootstrapSecret) < 32:
+		log.Fatal().Msg("BOOTSTRAP_SECRET must be at least 32 characters")
+	case bootstrapSecret == "" && db == nil && sqlitePath(dbUrl) == "":
+		log.Warn().Msg("BOOTSTRAP_SECRET is not set, no API client can be issued for the memory store")
 	}
-	defer dbInstance.Close()
-	log.Info().Msg("Connected to the DB")
 
-	db := repository.New(dbInstance)
+	authn := auth.NewAuthenticator(store, auth.DefaultTTL)
+	router := api.SetupRoutes(store, db, keys, barrier, authn, bootstrapSecret, &log.Logger)
 
-	router := api.SetupRoutes(db, &log.Logger)
+	reloader, err := newTLSReloader()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid TLS configuration")
+	}
+	if reloader == nil {
+		log.Info().Msg("Starting server on :9002")
+		if err := http.ListenAndServe(":9002", router); err != nil {
+			log.Fatal().Err(err).Msg("Failed to start server")
+		}
+		return
+	}
 
-	log.Info().Msg("Starting server on :9002")
-	if err := http.ListenAndServe(":9002", router); err != nil {
+	go reloader.Run(context.Background())
+	server := &http.Server{Addr: ":9002", Handler: router, TLSConfig: reloader.TLSConfig()}
+	log.Info().Msg("Starting TLS server on :9002")
+	if err := server.ListenAndServeTLS("", ""); err != nil {
 		log.Fatal().Err(err).Msg("Failed to start server")
 	}
 }
 
+// newTLSReloader reads TLS_CERT_FILE and TLS_KEY_FILE, which turn on TLS, and
+// TLS_CLIENT_CA_FILE, which turns on mTLS. TLS_CLIENT_AUTH=require refuses connections
+// without a client certificate, the default "optional" lets clients that present none
+// authenticate with a token. It returns nil when TLS is not configured.
+func newTLSReloader() (*tlsconfig.Reloader, error) {
+	opts := tlsconfig.Options{
+		CertFile:     os.Getenv("TLS_CERT_FILE"),
+		KeyFile:      os.Getenv("TLS_KEY_FILE"),
+		ClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+	}
+	if opts.CertFile == "" && opts.KeyFile == "" {
+		if opts.ClientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+
+	switch value := os.Getenv("TLS_CLIENT_AUTH"); value {
+	case "", "optional":
+		opts.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		opts.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("TLS_CLIENT_AUTH must be optional or require, got %q", value)
+	}
+	if os.Getenv("TLS_CLIENT_AUTH") != "" && opts.ClientCAFile == "" {
+		return nil, errors.New("TLS_CLIENT_AUTH requires TLS_CLIENT_CA_FILE")
+	}
+
+	return tlsconfig.NewReloader(opts, &log.Logger)
+}
+
+// newKeyCache reads KEY_CACHE_TTL, a duration such as "5m", and KEY_CACHE_SIZE, the
+// maximum number of cached key versions. Both fall back to the keycache defaults.
+func newKeyCache(store repository.KeyStore, barrier *seal.Barrier) (*keycache.Cache, error) {
+	ttl := keycache.DefaultTTL
+	if value := os.Getenv("KEY_CACHE_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("KEY_CACHE_TTL must be a positive duration, got %q", value)
+		}
+		ttl = parsed
+	}
+
+	size := keycache.DefaultMaxEntries
+	if value := os.Getenv("KEY_CACHE_SIZE"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("KEY_CACHE_SIZE must be a positive integer, got %q", value)
+		}
+		size = parsed
+	}
+
+	return keycache.New(store, barrier, ttl, size, &log.Logger), nil
+}
+
+// sqlitePath returns the file path of a sqlite:// or file:// URL, or "" for other URLs.
+// sqlite:///var/lib/keystore.db is absolute, sqlite://keystore.db relative.
+func sqlitePath(dbUrl string) string {
+	for _, scheme := range []string{"sqlite://", "file://"} {
+		if path, ok := strings.CutPrefix(dbUrl, scheme); ok {
+			return path
+		}
+	}
+	return ""
+}
+
 func initDatabase(dbUrl string) (*sql.DB, error) {
 	db, err := sql.Open("pgx", dbUrl)
 	if err != nil {
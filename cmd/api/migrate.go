@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/pressly/goose/v3"
+	"github.com/rs/zerolog/log"
+	"github.com/valu/encrpytion/migrations"
+)
+
+const migrateUsage = "usage: migrate status|up|down|redo"
+
+// migrateUp applies every pending migration. It runs on every start, so a fresh
+// database just works and replicas never serve an outdated schema.
+func migrateUp(ctx context.Context, db *sql.DB) error {
+	provider, err := migrations.NewProvider(db)
+	if err != nil {
+		return err
+	}
+	results, err := provider.Up(ctx)
+	logMigrations(results)
+	if err != nil {
+		return err
+	}
+
+	version, err := provider.GetDBVersion(ctx)
+	if err != nil {
+		return err
+	}
+	log.Info().Int64("version", version).Msg("Database schema is up to date")
+	return nil
+}
+
+// runMigrate implements the migrate subcommand against the Postgres database in DB_URL:
+//
+//	status  lists every migration and when it was applied
+//	up      applies every pending migration
+//	down    rolls back the most recent migration
+//	redo    rolls back the most recent migration and applies it again
+func runMigrate(ctx context.Context, dbUrl string, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+	if dbUrl == "" {
+		return errors.New("DB_URL environment variable is not set")
+	}
+	if sqlitePath(dbUrl) != "" {
+		return errors.New("migrations only apply to Postgres, the SQLite store creates its schema when it is opened")
+	}
+
+	db, err := initDatabase(dbUrl)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	provider, err := migrations.NewProvider(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "Pending"
+			if status.State == goose.StateApplied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%-25s %s\n", appliedAt, path.Base(status.Source.Path))
+		}
+		return nil
+	case "up":
+		results, err := provider.Up(ctx)
+		logMigrations(results)
+		if err == nil && len(results) == 0 {
+			log.Info().Msg("No pending migrations")
+		}
+		return err
+	case "down":
+		result, err := provider.Down(ctx)
+		logMigrations([]*goose.MigrationResult{result})
+		return err
+	case "redo":
+		result, err := provider.Down(ctx)
+		logMigrations([]*goose.MigrationResult{result})
+		if err != nil {
+			return err
+		}
+		result, err = provider.UpByOne(ctx)
+		logMigrations([]*goose.MigrationResult{result})
+		return err
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+func logMigrations(results []*goose.MigrationResult) {
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		event := log.Info()
+		if result.Error != nil {
+			event = log.Error().Err(result.Error)
+		}
+		event.Str("migration", path.Base(result.Source.Path)).Str("direction", result.Direction).
+			Dur("duration", result.Duration).Msg("Migration applied")
+	}
+}
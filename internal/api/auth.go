@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/auth"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/pkg/errs"
+)
+
+type clientContextKey struct{}
+
+// authenticate identifies the caller by the API token in the Authorization header,
+// "Bearer <token>", or when there is none by the verified TLS client certificate, see
+// auth.CertificateIdentity. It stores the client in the request context for requireScope
+// and writes an audit log entry for every request it lets through.
+func authenticate(authn *auth.Authenticator, log *zerolog.Logger) func(http.Handler) http.Handler {
+	return authenticateRequests(authn, log, false)
+}
+
+// authenticateIfPresent is authenticate for routes open to anonymous callers, where
+// only some requests need a client. Requests without credentials pass through with no
+// client in their context, invalid credentials are still refused.
+func authenticateIfPresent(authn *auth.Authenticator, log *zerolog.Logger) func(http.Handler) http.Handler {
+	return authenticateRequests(authn, log, true)
+}
+
+func authenticateRequests(authn *auth.Authenticator, log *zerolog.Logger, optional bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var client *model.APIClient
+			var identity string
+			var err error
+			header := r.Header.Get("Authorization")
+			hasCertificate := r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+			if optional && header == "" && !hasCertificate {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if header == "" && hasCertificate {
+				identity = auth.CertificateIdentity(r.TLS.VerifiedChains[0][0])
+				client, err = authn.AuthenticateCertificate(r.Context(), identity)
+			} else {
+				scheme, token, _ := strings.Cut(header, " ")
+				if !strings.EqualFold(scheme, "Bearer") || token == "" {
+					errs.UnauthorizedResponse(w, r)
+					return
+				}
+				client, err = authn.Authenticate(r.Context(), strings.TrimSpace(token))
+			}
+			switch {
+			case errors.Is(err, auth.ErrInvalidToken):
+				errs.UnauthorizedResponse(w, r)
+				return
+			case errors.Is(err, auth.ErrUnknownIdentity) && optional:
+				// A certificate trusted for TLS but bound to no client is anonymous here.
+				next.ServeHTTP(w, r)
+				return
+			case errors.Is(err, auth.ErrUnknownIdentity):
+				errs.ForbiddenResponse(w, r, fmt.Errorf("certificate identity %q is not bound to an API client", identity))
+				return
+			case err != nil:
+				log.Error().Err(err).Msg("Failed to authenticate API client")
+				errs.ServerErrorResponse(w, r, err)
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			// Deferred so that aborted streams are audited too.
+			defer func() {
+				event := log.Info().Str("client_id", client.ID.String()).Str("client", client.Name)
+				if identity != "" {
+					event = event.Str("certificate_identity", identity)
+				}
+				event.Str("method", r.Method).Str("path", r.URL.Path).Int("status", ww.Status()).Msg("Audit")
+			}()
+
+			ctx := context.WithValue(r.Context(), clientContextKey{}, client)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+		})
+	}
+}
+
+// requireScope rejects requests whose client lacks any of the scopes with 403. It must
+// run after authenticate.
+func requireScope(scopes ...model.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := clientFromContext(r.Context())
+			for _, scope := range scopes {
+				if client == nil || !client.HasScope(scope) {
+					errs.ForbiddenResponse(w, r, fmt.Errorf("the API client lacks the %s scope", scope))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientFromContext returns the client stored by authenticate, or nil.
+func clientFromContext(ctx context.Context) *model.APIClient {
+	client, _ := ctx.Value(clientContextKey{}).(*model.APIClient)
+	return client
+}
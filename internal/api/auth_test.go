@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/auth"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository/memory"
+)
+
+// newTestAuthenticator returns an authenticator with one client holding the
+// crypto:encrypt scope, and that client's token.
+func newTestAuthenticator(t *testing.T) (*auth.Authenticator, string) {
+	t.Helper()
+	authn := auth.NewAuthenticator(memory.New(), 0)
+	_, token, err := authn.Issue(context.Background(), "test", []model.Scope{model.ScopeCryptoEncrypt})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	return authn, token
+}
+
+// serve runs a request with the given Authorization header through the middleware and
+// returns the status code and the client the final handler saw.
+func serve(middleware func(http.Handler) http.Handler, authorization string) (int, *model.APIClient) {
+	return serveTLS(middleware, authorization, nil)
+}
+
+// serveTLS is serve for a request over mTLS, cert is the verified client certificate.
+func serveTLS(middleware func(http.Handler) http.Handler, authorization string, cert *x509.Certificate) (int, *model.APIClient) {
+	var client *model.APIClient
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client = clientFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/v1/keys/test/encrypt", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	if cert != nil {
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, client
+}
+
+func TestAuthenticate(t *testing.T) {
+	log := zerolog.Nop()
+	authn, token := newTestAuthenticator(t)
+	middleware := authenticate(authn, &log)
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"missing header", "", http.StatusUnauthorized},
+		{"basic scheme", "Basic " + token, http.StatusUnauthorized},
+		{"empty token", "Bearer ", http.StatusUnauthorized},
+		{"malformed token", "Bearer ek_nope", http.StatusUnauthorized},
+		{"wrong secret", "Bearer " + token[:len(token)-4] + "AAAA", http.StatusUnauthorized},
+		{"valid token", "Bearer " + token, http.StatusNoContent},
+		{"lowercase scheme", "bearer " + token, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, client := serve(middleware, tt.authorization)
+			if code != tt.want {
+				t.Fatalf("status = %d, want %d", code, tt.want)
+			}
+			if (code == http.StatusNoContent) != (client != nil) {
+				t.Fatalf("client in context = %v with status %d", client, code)
+			}
+		})
+	}
+}
+
+func TestAuthenticateIfPresent(t *testing.T) {
+	log := zerolog.Nop()
+	authn, token := newTestAuthenticator(t)
+	middleware := authenticateIfPresent(authn, &log)
+
+	code, client := serve(middleware, "")
+	if code != http.StatusNoContent || client != nil {
+		t.Fatalf("anonymous request = %d with client %v, want %d without a client", code, client, http.StatusNoContent)
+	}
+	if code, _ := serve(middleware, "Bearer ek_nope"); code != http.StatusUnauthorized {
+		t.Fatalf("invalid token = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, client := serve(middleware, "Bearer "+token); code != http.StatusNoContent || client == nil {
+		t.Fatalf("valid token = %d with client %v, want %d with a client", code, client, http.StatusNoContent)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	log := zerolog.Nop()
+	authn, token := newTestAuthenticator(t)
+	chain := func(scopes ...model.Scope) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return authenticate(authn, &log)(requireScope(scopes...)(next))
+		}
+	}
+
+	if code, _ := serve(chain(model.ScopeCryptoEncrypt), "Bearer "+token); code != http.StatusNoContent {
+		t.Fatalf("granted scope = %d, want %d", code, http.StatusNoContent)
+	}
+	if code, _ := serve(chain(model.ScopeCryptoDecrypt), "Bearer "+token); code != http.StatusForbidden {
+		t.Fatalf("missing scope = %d, want %d", code, http.StatusForbidden)
+	}
+	if code, _ := serve(chain(model.ScopeCryptoEncrypt, model.ScopeCryptoSign), "Bearer "+token); code != http.StatusForbidden {
+		t.Fatalf("one of two scopes = %d, want %d", code, http.StatusForbidden)
+	}
+	// Without a client, as behind authenticateIfPresent, every scope is missing.
+	if code, _ := serve(requireScope(model.ScopeCryptoEncrypt), ""); code != http.StatusForbidden {
+		t.Fatalf("anonymous request = %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestAuthenticateCertificate(t *testing.T) {
+	log := zerolog.Nop()
+	authn, token := newTestAuthenticator(t)
+	bound, err := authn.IssueCertificateClient(context.Background(), "billing", "CN=billing,O=Acme", []model.Scope{model.ScopeCryptoDecrypt})
+	if err != nil {
+		t.Fatalf("IssueCertificateClient: %v", err)
+	}
+	boundCert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing", Organization: []string{"Acme"}}}
+	unboundCert := &x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}}
+
+	code, client := serveTLS(authenticate(authn, &log), "", boundCert)
+	if code != http.StatusNoContent || client == nil || client.ID != bound.ID {
+		t.Fatalf("bound certificate = %d with client %v, want %d with client %s", code, client, http.StatusNoContent, bound.ID)
+	}
+	// A token takes precedence over the certificate.
+	if code, client := serveTLS(authenticate(authn, &log), "Bearer "+token, boundCert); code != http.StatusNoContent || client.ID == bound.ID {
+		t.Fatalf("token over a bound certificate = %d with client %v, want the token's client", code, client)
+	}
+	if code, _ := serveTLS(authenticate(authn, &log), "", unboundCert); code != http.StatusForbidden {
+		t.Fatalf("unbound certificate = %d, want %d", code, http.StatusForbidden)
+	}
+	if code, client := serveTLS(authenticateIfPresent(authn, &log), "", unboundCert); code != http.StatusNoContent || client != nil {
+		t.Fatalf("unbound certificate on an open route = %d with client %v, want %d without a client", code, client, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/seal"
+	"github.com/valu/encrpytion/pkg/crypto"
+	"github.com/valu/encrpytion/pkg/errs"
+	"github.com/valu/encrpytion/pkg/jsn"
+)
+
+// maxBatchItems bounds the work one batch request can queue, the JSON body limit bounds
+// its size.
+const maxBatchItems = 1000
+
+type batchEncryptItem struct {
+	Message           string                   `json:"message"`
+	PlaintextB64      string                   `json:"plaintext_b64"`
+	EncryptionContext crypto.EncryptionContext `json:"encryption_context"`
+}
+
+type batchEncryptResult struct {
+	Ciphertext string `json:"ciphertext,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type batchDecryptItem struct {
+	Ciphertext        string                   `json:"ciphertext"`
+	EncryptionContext crypto.EncryptionContext `json:"encryption_context"`
+}
+
+type batchDecryptResult struct {
+	DecryptedMessage string `json:"decrypted_message,omitempty"`
+	PlaintextB64     string `json:"plaintext_b64,omitempty"`
+	KeyName          string `json:"key_name,omitempty"`
+	KeyVersion       int    `json:"key_version,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// EncryptBatch encrypts every item under the primary version of one key, looked up once
+// for the whole batch. It takes ?mode= like EncryptMessage. Results are in the order of
+// the items, an item that fails has an error instead of a ciphertext.
+func (h *CryptoHandler) EncryptBatch(w http.ResponseWriter, r *http.Request) {
+	purpose, encrypt, err := encryptMode(w, r)
+	if err != nil {
+		return
+	}
+
+	var req struct {
+		KeyName string             `json:"key_name"`
+		Items   []batchEncryptItem `json:"items"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		h.log.Error().Err(err).Msg("Failed to read request")
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	if err := validateBatchSize(len(req.Items)); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	currentKey, err := h.primaryKey(w, r, req.KeyName, purpose)
+	if err != nil {
+		return
+	}
+
+	results := make([]batchEncryptResult, len(req.Items))
+	var failed atomic.Int64
+	parallel(len(req.Items), func(i int) {
+		item := req.Items[i]
+		plaintext, err := requestPlaintext(item.Message, item.PlaintextB64)
+		if err != nil {
+			results[i].Error = err.Error()
+			failed.Add(1)
+			return
+		}
+		ciphertext, err := encrypt(plaintext, item.EncryptionContext, currentKey)
+		if err != nil {
+			h.log.Error().Err(err).Int("item", i).Msg("Failed to encrypt batch item")
+			results[i].Error = "encryption failed"
+			failed.Add(1)
+			return
+		}
+		results[i].Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+	})
+
+	response := struct {
+		KeyName    string               `json:"key_name"`
+		KeyID      string               `json:"key_id"`
+		KeyVersion int                  `json:"key_version"`
+		Failed     int64                `json:"failed"`
+		Results    []batchEncryptResult `json:"results"`
+	}{
+		KeyName:    currentKey.Name,
+		KeyID:      currentKey.KeyID.String(),
+		KeyVersion: currentKey.Version,
+		Failed:     failed.Load(),
+		Results:    results,
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// DecryptBatch decrypts envelopes, which may name different versions or keys. Every
+// version is looked up once for the whole batch. When key_name is set every envelope
+// must belong to that key. Results are in the order of the items, an item that fails
+// has an error instead of a message. Legacy ciphertexts are not accepted.
+func (h *CryptoHandler) DecryptBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		KeyName string             `json:"key_name"`
+		Items   []batchDecryptItem `json:"items"`
+		// Encoding is the plaintext encoding of every result, see DecryptMessage.
+		Encoding string `json:"encoding"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		h.log.Error().Err(err).Msg("Failed to read request")
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	if err := validateBatchSize(len(req.Items)); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	if err := validatePlaintextEncoding(req.Encoding); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	results := make([]batchDecryptResult, len(req.Items))
+	envelopes := make([]*crypto.Envelope, len(req.Items))
+	for i, item := range req.Items {
+		ciphertext, err := base64.StdEncoding.DecodeString(item.Ciphertext)
+		if err != nil || item.Ciphertext == "" {
+			results[i].Error = "ciphertext must be provided as base64"
+			continue
+		}
+		envelopes[i], err = crypto.ParseEnvelope(ciphertext)
+		if err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+
+	// keys holds the master key of every version the batch names, keyErrs the reason a
+	// version cannot decrypt.
+	keys := make(map[uuid.UUID]*crypto.MasterKey)
+	keyErrs := make(map[uuid.UUID]error)
+	for _, envelope := range envelopes {
+		if envelope == nil || keys[envelope.KeyID] != nil || keyErrs[envelope.KeyID] != nil {
+			continue
+		}
+		key, err := h.keys.Get(r.Context(), envelope.KeyID)
+		switch {
+		case errors.Is(err, seal.ErrSealed):
+			errs.SealedResponse(w, r)
+			return
+		case errors.Is(err, sql.ErrNoRows):
+			keyErrs[envelope.KeyID] = errors.New("ciphertext references an unknown key")
+		case err != nil:
+			h.log.Error().Err(err).Msg("Failed to get key")
+			errs.ServerErrorResponse(w, r, err)
+			return
+		case req.KeyName != "" && key.Name != req.KeyName:
+			keyErrs[envelope.KeyID] = fmt.Errorf("ciphertext was not encrypted with key %q", req.KeyName)
+		case !model.KeyStatus(key.Status).CanDecrypt():
+			keyErrs[envelope.KeyID] = fmt.Errorf("version %d of key %q is %s", key.Version, key.Name, key.Status)
+		default:
+			keys[envelope.KeyID] = key
+		}
+	}
+
+	var failed atomic.Int64
+	parallel(len(req.Items), func(i int) {
+		envelope := envelopes[i]
+		if envelope == nil {
+			failed.Add(1)
+			return
+		}
+		if err := keyErrs[envelope.KeyID]; err != nil {
+			results[i].Error = err.Error()
+			failed.Add(1)
+			return
+		}
+
+		masterKey := keys[envelope.KeyID]
+		message, err := crypto.DecryptMessage(envelope, req.Items[i].EncryptionContext, masterKey)
+		if err != nil {
+			h.log.Error().Err(err).Int("item", i).Msg("Failed to decrypt batch item")
+			results[i].Error = "decryption failed, the ciphertext or encryption context is invalid"
+			failed.Add(1)
+			return
+		}
+		results[i] = batchDecryptResult{KeyName: masterKey.Name, KeyVersion: masterKey.Version}
+		if req.Encoding == plaintextEncodingBase64 {
+			results[i].PlaintextB64 = base64.StdEncoding.EncodeToString(message)
+		} else {
+			results[i].DecryptedMessage = string(message)
+		}
+	})
+
+	response := struct {
+		Failed  int64                `json:"failed"`
+		Results []batchDecryptResult `json:"results"`
+	}{
+		Failed:  failed.Load(),
+		Results: results,
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func validateBatchSize(n int) error {
+	if n == 0 || n > maxBatchItems {
+		return fmt.Errorf("items must hold between 1 and %d items", maxBatchItems)
+	}
+	return nil
+}
+
+// parallel calls fn for every index below n on at most GOMAXPROCS goroutines, items
+// are CPU bound. It returns once every call has returned.
+func parallel(n int, fn func(i int)) {
+	workers := min(n, runtime.GOMAXPROCS(0))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < n; i = int(next.Add(1) - 1) {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/auth"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/pkg/errs"
+	"github.com/valu/encrpytion/pkg/jsn"
+)
+
+type ClientHandler struct {
+	db    repository.KeyStore
+	authn *auth.Authenticator
+	log   *zerolog.Logger
+}
+
+// IssueClient creates an API client and returns its token. The token is only ever
+// returned here, a lost token is replaced by issuing a new client and revoking the old.
+// A client given a certificate_identity authenticates with mTLS client certificates of
+// that identity instead and gets no token.
+func (h *ClientHandler) IssueClient(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name                string        `json:"name"`
+		Scopes              []model.Scope `json:"scopes"`
+		CertificateIdentity string        `json:"certificate_identity"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	if err := model.ValidateClient(req.Name, req.Scopes); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	var client *model.APIClient
+	var token string
+	var err error
+	if req.CertificateIdentity != "" {
+		if err := model.ValidateCertificateIdentity(req.CertificateIdentity); err != nil {
+			errs.BadRequestResponse(w, r, err)
+			return
+		}
+		client, err = h.authn.IssueCertificateClient(r.Context(), req.Name, req.CertificateIdentity, req.Scopes)
+	} else {
+		client, token, err = h.authn.Issue(r.Context(), req.Name, req.Scopes)
+	}
+	if errors.Is(err, repository.ErrIdentityTaken) {
+		errs.ConflictResponse(w, r, err)
+		return
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to issue API client")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+	h.log.Info().Str("client_id", client.ID.String()).Str("client", client.Name).
+		Str("issued_by", clientFromContext(r.Context()).Name).Msg("Issued API client")
+
+	response := struct {
+		*model.APIClient
+		Token string `json:"token,omitempty"`
+	}{
+		APIClient: client,
+		Token:     token,
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusCreated, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (h *ClientHandler) ListClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.db.ListAPIClients(r.Context())
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to list API clients")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+	if clients == nil {
+		clients = []*model.APIClient{}
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, clients, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// RevokeClient refuses the client's token from now on. Other replicas may accept it for
+// up to auth.DefaultTTL while it is cached there. Revoking twice keeps the first date.
+func (h *ClientHandler) RevokeClient(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	client, err := h.authn.Revoke(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		errs.NotFoundResponse(w, r)
+		return
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to revoke API client")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+	h.log.Info().Str("client_id", client.ID.String()).Str("client", client.Name).
+		Str("revoked_by", clientFromContext(r.Context()).Name).Msg("Revoked API client")
+
+	if err := jsn.WriteJSON(w, http.StatusOK, client, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
@@ -1,39 +1,63 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/keycache"
+	"github.com/valu/encrpytion/internal/model"
 	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/seal"
 	"github.com/valu/encrpytion/pkg/crypto"
 	"github.com/valu/encrpytion/pkg/errs"
 	"github.com/valu/encrpytion/pkg/jsn"
 )
 
 type CryptoHandler struct {
-	db  *repository.DB
-	log *zerolog.Logger
+	db      repository.KeyStore
+	keys    *keycache.Cache
+	barrier *seal.Barrier
+	log     *zerolog.Logger
 }
 
+// EncryptMessage encrypts with random nonces, or with ?mode=deterministic under a key of
+// purpose deterministic, where equal messages give equal ciphertexts.
 func (h *CryptoHandler) EncryptMessage(w http.ResponseWriter, r *http.Request) {
+	purpose, encrypt, err := encryptMode(w, r)
+	if err != nil {
+		return
+	}
+
 	var req struct {
+		KeyName string `json:"key_name"`
 		Message string `json:"message"`
+		// PlaintextB64 replaces Message for binary plaintext, which JSON strings cannot carry.
+		PlaintextB64      string                   `json:"plaintext_b64"`
+		EncryptionContext crypto.EncryptionContext `json:"encryption_context"`
 	}
 	if err := jsn.ReadJSON(w, r, &req); err != nil {
 		h.log.Error().Err(err).Msg("Failed to read request")
 		errs.BadRequestResponse(w, r, err)
 		return
 	}
+	plaintext, err := requestPlaintext(req.Message, req.PlaintextB64)
+	if err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
 
-	currentKey, err := h.db.GetCurrentActiveKey(r.Context())
+	currentKey, err := h.primaryKey(w, r, req.KeyName, purpose)
 	if err != nil {
-		h.log.Error().Err(err).Msg("Failed to get current key")
-		errs.ServerErrorResponse(w, r, err)
 		return
 	}
 
-	encryptedMessage, encryptedDataKey, err := crypto.EncryptMessage([]byte(req.Message), currentKey)
+	ciphertext, err := encrypt(plaintext, req.EncryptionContext, currentKey)
 	if err != nil {
 		h.log.Error().Err(err).Msg("Failed to encrypt message")
 		errs.ServerErrorResponse(w, r, err)
@@ -41,70 +65,345 @@ func (h *CryptoHandler) EncryptMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := struct {
-		EncryptedMessage string `json:"encrypted_message"`
-		EncryptedDataKey string `json:"encrypted_data_key"`
-		KeyVersion       int    `json:"key_version"`
+		Ciphertext string `json:"ciphertext"`
+		KeyName    string `json:"key_name"`
+		KeyID      string `json:"key_id"`
+		KeyVersion int    `json:"key_version"`
 	}{
-		EncryptedMessage: base64.StdEncoding.EncodeToString(encryptedMessage),
-		EncryptedDataKey: base64.StdEncoding.EncodeToString(encryptedDataKey),
-		KeyVersion:       currentKey.Version,
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+		KeyName:    currentKey.Name,
+		KeyID:      currentKey.KeyID.String(),
+		KeyVersion: currentKey.Version,
 	}
 
 	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
 		h.log.Error().Err(err).Msg("Failed to write response")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		errs.ServerErrorResponse(w, r, err)
 		return
 	}
 }
 
 func (h *CryptoHandler) DecryptMessage(w http.ResponseWriter, r *http.Request) {
 	var req struct {
+		// KeyName is optional for envelopes, which name their key, but when given it must match.
+		// Legacy ciphertexts are looked up in the default key unless it is set.
+		KeyName string `json:"key_name"`
+		// KeyVersion marks the ciphertext as raw HPKE or RSA-OAEP output that a partner
+		// made offline with the public half of this version of an asymmetric_decrypt key.
+		KeyVersion        int                      `json:"key_version"`
+		Ciphertext        string                   `json:"ciphertext"`
+		EncryptionContext crypto.EncryptionContext `json:"encryption_context"`
+		// Legacy ciphertexts, produced before the envelope format, are sent as two fields.
 		EncryptedMessage string `json:"encrypted_message"`
 		EncryptedDataKey string `json:"encrypted_data_key"`
+		// Encoding "base64" returns the plaintext as plaintext_b64 instead of
+		// decrypted_message, for binary plaintext.
+		Encoding string `json:"encoding"`
 	}
 	if err := jsn.ReadJSON(w, r, &req); err != nil {
 		h.log.Error().Err(err).Msg("Failed to read request")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	if err := validatePlaintextEncoding(req.Encoding); err != nil {
+		errs.BadRequestResponse(w, r, err)
 		return
 	}
 
-	keyVersions, err := h.db.GetAllKeyVersions(r.Context())
+	var decryptedMessage []byte
+	var err error
+	if req.KeyVersion != 0 {
+		decryptedMessage, err = h.decryptAsymmetric(w, r, req.KeyName, req.KeyVersion, req.Ciphertext, req.EncryptionContext)
+	} else if req.Ciphertext != "" {
+		decryptedMessage, err = h.decryptEnvelope(w, r, req.KeyName, req.Ciphertext, req.EncryptionContext)
+	} else {
+		if len(req.EncryptionContext) > 0 {
+			errs.BadRequestResponse(w, r, errors.New("legacy ciphertexts do not support an encryption context"))
+			return
+		}
+		if req.KeyName == "" {
+			req.KeyName = model.DefaultKeyName
+		}
+		decryptedMessage, err = h.decryptLegacy(w, r, req.KeyName, req.EncryptedMessage, req.EncryptedDataKey)
+	}
 	if err != nil {
-		h.log.Error().Err(err).Msg("Failed to get key versions")
-		http.Error(w, "Failed to retrieve key versions", http.StatusInternalServerError)
+		// The helpers have already written the error response.
 		return
 	}
 
-	encryptedMessage, err := base64.StdEncoding.DecodeString(req.EncryptedMessage)
-	if err != nil {
-		h.log.Error().Err(err).Msg("Failed to decode encrypted_message")
-		http.Error(w, "Invalid encrypted_message", http.StatusBadRequest)
+	var response struct {
+		DecryptedMessage *string `json:"decrypted_message,omitempty"`
+		PlaintextB64     *string `json:"plaintext_b64,omitempty"`
+	}
+	if req.Encoding == plaintextEncodingBase64 {
+		encoded := base64.StdEncoding.EncodeToString(decryptedMessage)
+		response.PlaintextB64 = &encoded
+	} else {
+		message := string(decryptedMessage)
+		response.DecryptedMessage = &message
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
 		return
 	}
+}
 
-	encryptedDataKey, err := base64.StdEncoding.DecodeString(req.EncryptedDataKey)
+// plaintextEncodingBase64 is the decrypt request encoding that returns the plaintext
+// base64 encoded.
+const plaintextEncodingBase64 = "base64"
+
+// requestPlaintext returns the plaintext of an encrypt request, which is either message
+// or, for binary data, plaintext_b64.
+func requestPlaintext(message, plaintextB64 string) ([]byte, error) {
+	if plaintextB64 == "" {
+		return []byte(message), nil
+	}
+	if message != "" {
+		return nil, errors.New("only one of message and plaintext_b64 may be provided")
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(plaintextB64)
 	if err != nil {
+		return nil, errors.New("plaintext_b64 must be base64 encoded")
+	}
+	return plaintext, nil
+}
+
+func validatePlaintextEncoding(encoding string) error {
+	if encoding != "" && encoding != plaintextEncodingBase64 {
+		return fmt.Errorf("unsupported encoding %q, only %q is supported", encoding, plaintextEncodingBase64)
+	}
+	return nil
+}
+
+type encryptFunc func([]byte, crypto.EncryptionContext, *crypto.MasterKey) ([]byte, error)
+
+// encryptMode resolves the ?mode= of an encrypt request to the key purpose it needs and
+// the function that encrypts. On error the response has already been written.
+func encryptMode(w http.ResponseWriter, r *http.Request) (model.KeyPurpose, encryptFunc, error) {
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "":
+		return model.KeyPurposeEncrypt, crypto.EncryptMessage, nil
+	case "deterministic":
+		return model.KeyPurposeDeterministic, crypto.EncryptDeterministic, nil
+	default:
+		err := fmt.Errorf("unsupported mode %q", mode)
+		errs.BadRequestResponse(w, r, err)
+		return "", nil, err
+	}
+}
+
+// decryptEnvelope looks up the single master key named in the envelope header.
+func (h *CryptoHandler) decryptEnvelope(w http.ResponseWriter, r *http.Request, keyName, encoded string, encryptionContext crypto.EncryptionContext) ([]byte, error) {
+	envelope, masterKey, err := h.envelopeKey(w, r, keyName, encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := crypto.DecryptMessage(envelope, encryptionContext, masterKey)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to decrypt message")
+		errs.BadRequestResponse(w, r, errors.New("decryption failed, the ciphertext or encryption context is invalid"))
+		return nil, err
+	}
+	return message, nil
+}
+
+// decryptAsymmetric decrypts a ciphertext made with the public half of the given key
+// version. Such ciphertexts have no header, so the caller names the key and version.
+func (h *CryptoHandler) decryptAsymmetric(w http.ResponseWriter, r *http.Request, keyName string, version int, encoded string, encryptionContext crypto.EncryptionContext) ([]byte, error) {
+	if keyName == "" || version < 1 {
+		err := errors.New("key_name and a positive key_version must be provided")
+		errs.BadRequestResponse(w, r, err)
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || encoded == "" {
+		errs.BadRequestResponse(w, r, errors.New("ciphertext must be provided as base64"))
+		return nil, errors.New("invalid ciphertext")
+	}
+
+	masterKey, err := h.versionKey(w, r, keyName, version, model.KeyPurposeAsymmetricDecrypt)
+	if err != nil {
+		return nil, err
+	}
+	if masterKey == nil {
+		err := fmt.Errorf("key %q has no version %d", keyName, version)
+		errs.BadRequestResponse(w, r, err)
+		return nil, err
+	}
+
+	message, err := crypto.DecryptAsymmetric(ciphertext, encryptionContext, masterKey)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to decrypt message")
+		errs.BadRequestResponse(w, r, errors.New("decryption failed, the ciphertext or encryption context is invalid"))
+		return nil, err
+	}
+	return message, nil
+}
+
+// decryptLegacy tries every version of the key, legacy ciphertexts do not name theirs.
+// It bypasses the key cache, these ciphertexts are expected to be rewrapped over time.
+func (h *CryptoHandler) decryptLegacy(w http.ResponseWriter, r *http.Request, keyName, encodedMessage, encodedDataKey string) ([]byte, error) {
+	rootKey, err := h.barrier.RootKey()
+	if err != nil {
+		errs.SealedResponse(w, r)
+		return nil, err
+	}
+
+	encryptedMessage, err := base64.StdEncoding.DecodeString(encodedMessage)
+	if err != nil || encodedMessage == "" {
+		h.log.Error().Err(err).Msg("Failed to decode encrypted_message")
+		errs.BadRequestResponse(w, r, errors.New("ciphertext or encrypted_message must be provided as base64"))
+		return nil, errors.New("invalid encrypted_message")
+	}
+
+	encryptedDataKey, err := base64.StdEncoding.DecodeString(encodedDataKey)
+	if err != nil || encodedDataKey == "" {
 		h.log.Error().Err(err).Msg("Failed to decode encrypted_data_key")
-		http.Error(w, "Invalid encrypted_data_key", http.StatusBadRequest)
-		return
+		errs.BadRequestResponse(w, r, errors.New("encrypted_data_key must be provided as base64"))
+		return nil, errors.New("invalid encrypted_data_key")
 	}
 
-	decryptedMessage, err := crypto.DecryptMessage(encryptedMessage, encryptedDataKey, keyVersions)
+	keyVersions, err := h.db.GetAllKeyVersions(r.Context(), keyName)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to get key versions")
+		errs.ServerErrorResponse(w, r, err)
+		return nil, err
+	}
+	for version, key := range keyVersions {
+		if !model.KeyStatus(key.Status).CanDecrypt() {
+			delete(keyVersions, version)
+		}
+	}
+
+	message, err := crypto.DecryptLegacyMessage(encryptedMessage, encryptedDataKey, keyVersions, rootKey)
 	if err != nil {
 		h.log.Error().Err(err).Msg("Failed to decrypt message")
-		http.Error(w, "Decryption failed: "+err.Error(), http.StatusInternalServerError)
-		return
+		errs.BadRequestResponse(w, r, errors.New("decryption failed"))
+		return nil, err
 	}
+	return message, nil
+}
 
-	response := struct {
-		DecryptedMessage string `json:"decrypted_message"`
-	}{
-		DecryptedMessage: string(decryptedMessage),
+// primaryKey returns the current primary version of the named key, falling back to the
+// default key when no name is given. Expired versions and keys of another purpose are
+// refused. On error the response has already been written.
+func (h *CryptoHandler) primaryKey(w http.ResponseWriter, r *http.Request, keyName string, purpose model.KeyPurpose) (*crypto.MasterKey, error) {
+	if keyName == "" {
+		keyName = model.DefaultKeyName
 	}
 
-	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
-		h.log.Error().Err(err).Msg("Failed to write response")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	key, err := h.keys.Primary(r.Context(), keyName)
+	if errors.Is(err, seal.ErrSealed) {
+		errs.SealedResponse(w, r)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		err := fmt.Errorf("key %q does not exist or has no active version", keyName)
+		errs.BadRequestResponse(w, r, err)
+		return nil, err
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to get current key")
+		errs.ServerErrorResponse(w, r, err)
+		return nil, err
+	}
+	if key.Suite().Purpose != purpose {
+		err := fmt.Errorf("key %q has purpose %s and cannot be used for %s", keyName, key.Suite().Purpose, purpose)
+		errs.BadRequestResponse(w, r, err)
+		return nil, err
+	}
+	if key.Expired(time.Now()) {
+		err := fmt.Errorf("primary version %d of key %q has expired, rotate the key", key.Version, keyName)
+		errs.ConflictResponse(w, r, err)
+		return nil, err
+	}
+	return key, nil
+}
+
+// envelopeKey parses a base64 envelope and looks up the master key version it names,
+// see decryptionKey. On error the response has already been written.
+func (h *CryptoHandler) envelopeKey(w http.ResponseWriter, r *http.Request, keyName, encoded string) (*crypto.Envelope, *crypto.MasterKey, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		errs.BadRequestResponse(w, r, errors.New("ciphertext must be base64 encoded"))
+		return nil, nil, err
+	}
+
+	envelope, err := crypto.ParseEnvelope(ciphertext)
+	if err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return nil, nil, err
+	}
+
+	masterKey, err := h.decryptionKey(w, r, keyName, envelope.KeyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return envelope, masterKey, nil
+}
+
+// decryptionKey looks up the master key version a ciphertext header names. When keyName
+// is set the version must belong to that key, and the version must be in a status that
+// allows decryption. On error the response has already been written.
+func (h *CryptoHandler) decryptionKey(w http.ResponseWriter, r *http.Request, keyName string, keyID uuid.UUID) (*crypto.MasterKey, error) {
+	masterKey, err := h.keys.Get(r.Context(), keyID)
+	if errors.Is(err, seal.ErrSealed) {
+		errs.SealedResponse(w, r)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		err := errors.New("ciphertext references an unknown key")
+		errs.BadRequestResponse(w, r, err)
+		return nil, err
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to get key")
+		errs.ServerErrorResponse(w, r, err)
+		return nil, err
+	}
+	if keyName != "" && masterKey.Name != keyName {
+		err := fmt.Errorf("ciphertext was not encrypted with key %q", keyName)
+		errs.BadRequestResponse(w, r, err)
+		return nil, err
+	}
+	if !model.KeyStatus(masterKey.Status).CanDecrypt() {
+		err := fmt.Errorf("version %d of key %q is %s", masterKey.Version, masterKey.Name, masterKey.Status)
+		errs.ConflictResponse(w, r, err)
+		return nil, err
+	}
+	return masterKey, nil
+}
+
+// versionKey looks up a version of the named key to verify a MAC or signature with, or
+// to decrypt an asymmetric ciphertext. It returns nil without an error when the version
+// does not exist, callers report that as an invalid MAC or signature. Versions must be in
+// a status that allows decryption. On error the response has already been written.
+func (h *CryptoHandler) versionKey(w http.ResponseWriter, r *http.Request, keyName string, version int, purpose model.KeyPurpose) (*crypto.MasterKey, error) {
+	key, err := h.keys.Version(r.Context(), keyName, version)
+	if errors.Is(err, seal.ErrSealed) {
+		errs.SealedResponse(w, r)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to get key")
+		errs.ServerErrorResponse(w, r, err)
+		return nil, err
+	}
+	if key.Suite().Purpose != purpose {
+		err := fmt.Errorf("key %q has purpose %s and cannot be used for %s", keyName, key.Suite().Purpose, purpose)
+		errs.BadRequestResponse(w, r, err)
+		return nil, err
+	}
+	if !model.KeyStatus(key.Status).CanDecrypt() {
+		err := fmt.Errorf("version %d of key %q is %s", key.Version, key.Name, key.Status)
+		errs.ConflictResponse(w, r, err)
+		return nil, err
 	}
+	return key, nil
 }
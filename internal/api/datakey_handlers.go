@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/pkg/crypto"
+	"github.com/valu/encrpytion/pkg/errs"
+	"github.com/valu/encrpytion/pkg/jsn"
+)
+
+// GenerateDataKey returns a fresh data key in plaintext and wrapped under the primary
+// version of the key, for callers that encrypt large payloads locally.
+func (h *CryptoHandler) GenerateDataKey(w http.ResponseWriter, r *http.Request) {
+	h.generateDataKey(w, r, true)
+}
+
+// GenerateDataKeyWithoutPlaintext only returns the wrapped data key, for callers that
+// store it now and decrypt it later through /v1/crypto/datakey/decrypt.
+func (h *CryptoHandler) GenerateDataKeyWithoutPlaintext(w http.ResponseWriter, r *http.Request) {
+	h.generateDataKey(w, r, false)
+}
+
+func (h *CryptoHandler) generateDataKey(w http.ResponseWriter, r *http.Request, withPlaintext bool) {
+	var req struct {
+		KeyName           string                   `json:"key_name"`
+		EncryptionContext crypto.EncryptionContext `json:"encryption_context"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	currentKey, err := h.primaryKey(w, r, req.KeyName, model.KeyPurposeEncrypt)
+	if err != nil {
+		return
+	}
+
+	dataKey, blob, err := crypto.GenerateDataKey(req.EncryptionContext, currentKey)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to generate data key")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	response := struct {
+		Plaintext      string `json:"plaintext,omitempty"`
+		CiphertextBlob string `json:"ciphertext_blob"`
+		KeyName        string `json:"key_name"`
+		KeyID          string `json:"key_id"`
+		KeyVersion     int    `json:"key_version"`
+	}{
+		CiphertextBlob: base64.StdEncoding.EncodeToString(blob),
+		KeyName:        currentKey.Name,
+		KeyID:          currentKey.KeyID.String(),
+		KeyVersion:     currentKey.Version,
+	}
+	if withPlaintext {
+		response.Plaintext = base64.StdEncoding.EncodeToString(dataKey)
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (h *CryptoHandler) DecryptDataKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		KeyName           string                   `json:"key_name"`
+		CiphertextBlob    string                   `json:"ciphertext_blob"`
+		EncryptionContext crypto.EncryptionContext `json:"encryption_context"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	envelope, masterKey, err := h.envelopeKey(w, r, req.KeyName, req.CiphertextBlob)
+	if err != nil {
+		return
+	}
+
+	dataKey, err := crypto.DecryptDataKey(envelope, req.EncryptionContext, masterKey)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to decrypt data key")
+		errs.BadRequestResponse(w, r, errors.New("decryption failed, the ciphertext blob or encryption context is invalid"))
+		return
+	}
+
+	response := struct {
+		Plaintext  string `json:"plaintext"`
+		KeyName    string `json:"key_name"`
+		KeyVersion int    `json:"key_version"`
+	}{
+		Plaintext:  base64.StdEncoding.EncodeToString(dataKey),
+		KeyName:    masterKey.Name,
+		KeyVersion: masterKey.Version,
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
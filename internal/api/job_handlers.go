@@ -0,0 +1,183 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/jobs"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/pkg/errs"
+	"github.com/valu/encrpytion/pkg/jsn"
+)
+
+const (
+	defaultJobBatchSize = 100
+	maxJobBatchSize     = 10_000
+)
+
+type JobHandler struct {
+	db  *repository.DB
+	log *zerolog.Logger
+}
+
+func (h *JobHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		KeyName string `json:"key_name"`
+		Source  struct {
+			Type             string `json:"type"`
+			Table            string `json:"table"`
+			IDColumn         string `json:"id_column"`
+			CiphertextColumn string `json:"ciphertext_column"`
+		} `json:"source"`
+		// BelowVersion defaults to the primary version, so every older envelope is rewrapped.
+		BelowVersion      int               `json:"below_version"`
+		BatchSize         int               `json:"batch_size"`
+		EncryptionContext map[string]string `json:"encryption_context"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	if req.Source.Type == "" {
+		req.Source.Type = model.JobSourcePostgres
+	}
+	if req.BatchSize == 0 {
+		req.BatchSize = defaultJobBatchSize
+	}
+	if req.BatchSize < 1 || req.BatchSize > maxJobBatchSize {
+		errs.BadRequestResponse(w, r, fmt.Errorf("batch_size must be between 1 and %d", maxJobBatchSize))
+		return
+	}
+
+	currentKey, err := h.db.GetCurrentActiveKey(r.Context(), req.KeyName)
+	if errors.Is(err, sql.ErrNoRows) {
+		errs.BadRequestResponse(w, r, fmt.Errorf("key %q does not exist or has no active version", req.KeyName))
+		return
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to get current key")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+	if req.BelowVersion == 0 {
+		req.BelowVersion = currentKey.Version
+	}
+	if req.BelowVersion < 1 || req.BelowVersion > currentKey.Version {
+		errs.BadRequestResponse(w, r, fmt.Errorf("below_version must be between 1 and the primary version %d", currentKey.Version))
+		return
+	}
+
+	now := time.Now()
+	job := model.RewrapJob{
+		ID:                uuid.New(),
+		KeyName:           req.KeyName,
+		SourceType:        req.Source.Type,
+		SourceTable:       req.Source.Table,
+		IDColumn:          req.Source.IDColumn,
+		CiphertextColumn:  req.Source.CiphertextColumn,
+		EncryptionContext: req.EncryptionContext,
+		BelowVersion:      req.BelowVersion,
+		BatchSize:         req.BatchSize,
+		Status:            string(model.JobStatusPending),
+		CreationDate:      now,
+		UpdatedDate:       now,
+	}
+
+	// The source is opened once up front so a typo in the table or columns fails here and not in the runner.
+	if _, err := jobs.NewSource(r.Context(), h.db.DB, &job); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	if err := h.db.CreateJob(r.Context(), &job); err != nil {
+		h.log.Error().Err(err).Msg("Failed to create rewrap job")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusAccepted, job, nil); err != nil {
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (h *JobHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	jobList, err := h.db.ListJobs(r.Context())
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to list rewrap jobs")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, jobList, nil); err != nil {
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	job, err := h.db.GetJob(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		errs.NotFoundResponse(w, r)
+		return
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to get rewrap job")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, job, nil); err != nil {
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// CancelJob stops a job at its next checkpoint. Rows rewrapped so far stay rewrapped.
+func (h *JobHandler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	cancelled, err := h.db.CancelJob(r.Context(), id)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to cancel rewrap job")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	job, err := h.db.GetJob(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		errs.NotFoundResponse(w, r)
+		return
+	}
+	if err != nil {
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+	if !cancelled {
+		errs.ConflictResponse(w, r, fmt.Errorf("job has already finished with status %s", job.Status))
+		return
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, job, nil); err != nil {
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
@@ -1,48 +1,144 @@
 package api
 
 import (
-	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
-	"time"
+	"strconv"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/keycache"
 	"github.com/valu/encrpytion/internal/model"
 	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/seal"
+	"github.com/valu/encrpytion/pkg/crypto"
 	"github.com/valu/encrpytion/pkg/errs"
 	"github.com/valu/encrpytion/pkg/jsn"
 )
 
 type KeyHandler struct {
-	db  *repository.DB
-	log *zerolog.Logger
+	db      repository.KeyStore
+	keys    *keycache.Cache
+	barrier *seal.Barrier
+	log     *zerolog.Logger
 }
 
 func (h *KeyHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
-	key := model.EncryptionKey{
-		KeyID:          uuid.New(),
-		CreationDate:   time.Now(),
-		Status:         string(model.KeyStatusActive),
-		Version:        1,
-		ExpirationDate: time.Now().AddDate(1, 0, 0), // 1 year from now
+	name := chi.URLParam(r, "name")
+	if err := model.ValidateKeyName(name); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	// The body is optional, a key can be created with a bare POST.
+	var req struct {
+		RotationPeriodDays int              `json:"rotation_period_days"`
+		Purpose            model.KeyPurpose `json:"purpose"`
+		Algorithm          string           `json:"algorithm"`
+	}
+	if r.ContentLength != 0 {
+		if err := jsn.ReadJSON(w, r, &req); err != nil {
+			errs.BadRequestResponse(w, r, err)
+			return
+		}
+	}
+	if err := model.ValidateRotationPeriod(req.RotationPeriodDays); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	if req.Purpose == "" {
+		req.Purpose = model.KeyPurposeEncrypt
+	}
+	algorithm, err := crypto.KeyAlgorithm(req.Purpose, req.Algorithm)
+	if err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
 	}
 
-	keyMaterial := make([]byte, 32)
-	_, err := rand.Read(keyMaterial) // -> be aware of package crpyto/rand and not math/rand
+	rootKey, err := h.barrier.RootKey()
+	if err != nil {
+		errs.SealedResponse(w, r)
+		return
+	}
+
+	key, err := crypto.NewKeyVersion(name, 1, algorithm, rootKey)
 	if err != nil {
 		errs.ServerErrorResponse(w, r, err)
 		return
 	}
 
-	key.EncryptedKeyMaterial = keyMaterial
+	keyring := model.Keyring{
+		Name:               name,
+		Purpose:            req.Purpose,
+		CreationDate:       key.CreationDate,
+		RotationPeriodDays: req.RotationPeriodDays,
+		PrimaryVersion:     key.Version,
+		Versions:           []*model.EncryptionKey{key},
+	}
 
-	err = h.db.CreateKey(r.Context(), &key)
+	err = h.db.CreateKeyring(r.Context(), &keyring, key)
+	if errors.Is(err, repository.ErrKeyringExists) {
+		errs.ConflictResponse(w, r, err)
+		return
+	}
 	if err != nil {
+		h.log.Error().Err(err).Str("name", name).Msg("Failed to create key")
 		errs.ServerErrorResponse(w, r, err)
 		return
 	}
 
-	if err := jsn.WriteJSON(w, http.StatusOK, key, nil); err != nil {
+	if err := jsn.WriteJSON(w, http.StatusOK, keyring, nil); err != nil {
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// UpdateKeyring changes the rotation period of a key. It applies from the next scheduler run.
+func (h *KeyHandler) UpdateKeyring(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	var req struct {
+		RotationPeriodDays int `json:"rotation_period_days"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	if err := model.ValidateRotationPeriod(req.RotationPeriodDays); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	err := h.db.UpdateRotationPeriod(r.Context(), name, req.RotationPeriodDays)
+	if errors.Is(err, sql.ErrNoRows) {
+		errs.NotFoundResponse(w, r)
+		return
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to update key")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	h.GetKeyring(w, r)
+}
+
+func (h *KeyHandler) GetKeyring(w http.ResponseWriter, r *http.Request) {
+	keyring, err := h.db.GetKeyring(r.Context(), chi.URLParam(r, "name"))
+	if errors.Is(err, sql.ErrNoRows) {
+		errs.NotFoundResponse(w, r)
+		return
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to get key")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, keyring, nil); err != nil {
 		errs.ServerErrorResponse(w, r, err)
 		return
 	}
@@ -80,44 +176,86 @@ func (h *KeyHandler) ListActiveKeys(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// RotateKey creates a new primary version. The rotation is a compare-and-swap on the
+// primary version: callers may pass expected_version, otherwise the version read at the
+// start of the request is used. A rotation that loses a race gets a 409 with the version
+// that won. The new version keeps the algorithm of the newest existing one.
 func (h *KeyHandler) RotateKey(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	name := chi.URLParam(r, "name")
 
-	currentKey, err := h.db.GetCurrentActiveKey(ctx)
+	var req struct {
+		ExpectedVersion *int `json:"expected_version"`
+	}
+	if r.ContentLength != 0 {
+		if err := jsn.ReadJSON(w, r, &req); err != nil {
+			errs.BadRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	rootKey, err := h.barrier.RootKey()
 	if err != nil {
-		h.log.Error().Err(err).Msg("Failed to get current active key")
-		http.Error(w, "Failed to get current active key", http.StatusInternalServerError)
+		errs.SealedResponse(w, r)
 		return
 	}
 
-	newKey := model.EncryptionKey{
-		KeyID:          uuid.New(),
-		CreationDate:   time.Now(),
-		Status:         string(model.KeyStatusActive),
-		Version:        currentKey.Version + 1,
-		ExpirationDate: time.Now().AddDate(1, 0, 0), // 1 year from now
+	keyring, err := h.db.GetKeyring(ctx, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		errs.NotFoundResponse(w, r)
+		return
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to get key")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	oldVersion := keyring.PrimaryVersion
+	if req.ExpectedVersion != nil {
+		oldVersion = *req.ExpectedVersion
 	}
 
-	newKey.EncryptedKeyMaterial = make([]byte, 32)
-	if _, err := rand.Read(newKey.EncryptedKeyMaterial); err != nil {
+	var algorithm string
+	if len(keyring.Versions) > 0 {
+		algorithm = keyring.Versions[0].Algorithm
+	}
+	newKey, err := crypto.NewKeyVersion(name, oldVersion+1, algorithm, rootKey)
+	if err != nil {
 		h.log.Error().Err(err).Msg("Failed to generate new key material")
 		errs.ServerErrorResponse(w, r, err)
 		return
 	}
 
-	err = h.db.RotateKey(ctx, currentKey.KeyID, &newKey)
-	if err != nil {
+	err = h.db.RotateKey(ctx, name, oldVersion, newKey)
+	var conflict *repository.VersionConflictError
+	switch {
+	case errors.As(err, &conflict):
+		errs.SendErrorResponseWithDetails(w, r, http.StatusConflict, conflict.Error(), map[string]interface{}{
+			"current_version": conflict.CurrentVersion,
+		})
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		errs.NotFoundResponse(w, r)
+		return
+	case err != nil:
 		h.log.Error().Err(err).Msg("Failed to rotate key")
 		errs.ServerErrorResponse(w, r, err)
 		return
 	}
+	// Other replicas drop the key when the change notification arrives, this one at once.
+	h.keys.Invalidate(name)
+	h.log.Info().Str("key_name", name).Int("old_version", oldVersion).Int("new_version", newKey.Version).
+		Str("reason", "manual").Msg("Key rotated")
 
 	response := struct {
 		Message       string `json:"message"`
+		Name          string `json:"name"`
 		NewKeyID      string `json:"new_key_id"`
 		NewKeyVersion int    `json:"new_key_version"`
 	}{
 		Message:       "Key rotated successfully",
+		Name:          name,
 		NewKeyID:      newKey.KeyID.String(),
 		NewKeyVersion: newKey.Version,
 	}
@@ -128,3 +266,72 @@ func (h *KeyHandler) RotateKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// GetPublicKey exports the public half of an asymmetric key as PEM and JWK. It returns
+// the primary version unless ?version= names another one.
+func (h *KeyHandler) GetPublicKey(w http.ResponseWriter, r *http.Request) {
+	keyring, err := h.db.GetKeyring(r.Context(), chi.URLParam(r, "name"))
+	if errors.Is(err, sql.ErrNoRows) {
+		errs.NotFoundResponse(w, r)
+		return
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to get key")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	version := keyring.PrimaryVersion
+	if v := r.URL.Query().Get("version"); v != "" {
+		if version, err = strconv.Atoi(v); err != nil {
+			errs.BadRequestResponse(w, r, errors.New("version must be a number"))
+			return
+		}
+	}
+	var key *model.EncryptionKey
+	for _, k := range keyring.Versions {
+		if k.Version == version {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		errs.NotFoundResponse(w, r)
+		return
+	}
+
+	pemKey, err := crypto.PublicKeyPEM(key)
+	if errors.Is(err, crypto.ErrNoPublicKey) {
+		errs.BadRequestResponse(w, r, fmt.Errorf("key %q is symmetric and has no public key", keyring.Name))
+		return
+	}
+	if err != nil {
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+	jwk, err := crypto.PublicKeyJWK(key)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to encode public key")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	response := struct {
+		KeyName    string      `json:"key_name"`
+		KeyVersion int         `json:"key_version"`
+		Algorithm  string      `json:"algorithm"`
+		PEM        string      `json:"pem"`
+		JWK        *crypto.JWK `json:"jwk"`
+	}{
+		KeyName:    keyring.Name,
+		KeyVersion: key.Version,
+		Algorithm:  key.Algorithm,
+		PEM:        pemKey,
+		JWK:        jwk,
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
@@ -6,28 +6,99 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/auth"
+	"github.com/valu/encrpytion/internal/keycache"
+	"github.com/valu/encrpytion/internal/model"
 	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/seal"
 )
 
-func SetupRoutes(database *repository.DB, log *zerolog.Logger) http.Handler {
-	kh := &KeyHandler{db: database, log: log}
-	ch := &CryptoHandler{db: database, log: log}
+// SetupRoutes mounts the API. Every route but initializing, unsealing and the seal status
+// requires an API token with the scopes of the route, initializing requires the
+// bootstrap secret when one is set. Rewrap jobs read and write application tables in
+// Postgres, so /v1/jobs is only mounted when jobStore is set.
+func SetupRoutes(store repository.KeyStore, jobStore *repository.DB, keys *keycache.Cache, barrier *seal.Barrier, authn *auth.Authenticator, bootstrapSecret string, log *zerolog.Logger) http.Handler {
+	kh := &KeyHandler{db: store, keys: keys, barrier: barrier, log: log}
+	ch := &CryptoHandler{db: store, keys: keys, barrier: barrier, log: log}
+	sh := &SysHandler{barrier: barrier, keys: keys, authn: authn, bootstrapSecret: bootstrapSecret, log: log}
+	clh := &ClientHandler{db: store, authn: authn, log: log}
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
+	authenticated := authenticate(authn, log)
+	keysAdmin := requireScope(model.ScopeKeysAdmin)
+	encrypt := requireScope(model.ScopeCryptoEncrypt)
+	decrypt := requireScope(model.ScopeCryptoDecrypt)
+	sign := requireScope(model.ScopeCryptoSign)
+	verify := requireScope(model.ScopeCryptoVerify)
+	mac := requireScope(model.ScopeCryptoMAC)
+
+	r.Route("/v1/sys", func(r chi.Router) {
+		r.Post("/init", sh.Init)
+		r.With(authenticateIfPresent(authn, log)).Post("/unseal", sh.Unseal)
+		r.With(authenticated, keysAdmin).Post("/seal", sh.Seal)
+		r.Get("/seal-status", sh.SealStatus)
+	})
+
+	r.Route("/v1/clients", func(r chi.Router) {
+		r.Use(authenticated, requireScope(model.ScopeClientsAdmin))
+		r.Post("/", clh.IssueClient)
+		r.Get("/", clh.ListClients)
+		r.Post("/{id}/revoke", clh.RevokeClient)
+	})
+
 	r.Route("/v1/keys", func(r chi.Router) {
-		r.Post("/", kh.CreateKey)
-		r.Get("/", kh.GetKey)
-		r.Get("/active", kh.ListActiveKeys)
-		r.Post("/rotate", kh.RotateKey)
+		r.Use(authenticated, requireUnsealed(barrier))
+		// Public keys are not secret, any client may fetch them.
+		r.Get("/{name}/public-key", kh.GetPublicKey)
+
+		r.Group(func(r chi.Router) {
+			r.Use(keysAdmin)
+			r.Get("/", kh.GetKey)
+			r.Get("/active", kh.ListActiveKeys)
+			r.Post("/{name}", kh.CreateKey)
+			r.Get("/{name}", kh.GetKeyring)
+			r.Patch("/{name}", kh.UpdateKeyring)
+			r.Post("/{name}/rotate", kh.RotateKey)
+			r.Post("/{name}/versions/{version}/disable", kh.DisableKeyVersion)
+			r.Post("/{name}/versions/{version}/enable", kh.EnableKeyVersion)
+			r.Post("/{name}/versions/{version}/schedule-deletion", kh.ScheduleKeyVersionDeletion)
+			r.Post("/{name}/versions/{version}/cancel-deletion", kh.CancelKeyVersionDeletion)
+			r.Post("/{name}/versions/{version}/destroy", kh.DestroyKeyVersion)
+		})
 	})
 
 	r.Route("/v1/crypto", func(r chi.Router) {
-		r.Post("/encrypt", ch.EncryptMessage)
-		r.Post("/decrypt", ch.DecryptMessage)
+		r.Use(authenticated, requireUnsealed(barrier))
+		r.With(encrypt).Post("/encrypt", ch.EncryptMessage)
+		r.With(decrypt).Post("/decrypt", ch.DecryptMessage)
+		r.With(encrypt).Post("/encrypt/stream", ch.EncryptStream)
+		r.With(decrypt).Post("/decrypt/stream", ch.DecryptStream)
+		r.With(encrypt).Post("/encrypt/batch", ch.EncryptBatch)
+		r.With(decrypt).Post("/decrypt/batch", ch.DecryptBatch)
+		r.With(encrypt).Post("/datakey", ch.GenerateDataKey)
+		r.With(encrypt).Post("/datakey/without-plaintext", ch.GenerateDataKeyWithoutPlaintext)
+		r.With(decrypt).Post("/datakey/decrypt", ch.DecryptDataKey)
+		// Rewrapping opens a ciphertext, so it needs both scopes.
+		r.With(encrypt, decrypt).Post("/rewrap", ch.Rewrap)
+		r.With(mac).Post("/mac", ch.GenerateMAC)
+		r.With(mac).Post("/mac/verify", ch.VerifyMAC)
+		r.With(sign).Post("/sign", ch.Sign)
+		r.With(verify).Post("/verify", ch.Verify)
 	})
 
+	if jobStore != nil {
+		jh := &JobHandler{db: jobStore, log: log}
+		r.Route("/v1/jobs", func(r chi.Router) {
+			r.Use(authenticated, keysAdmin, requireUnsealed(barrier))
+			r.Post("/", jh.CreateJob)
+			r.Get("/", jh.ListJobs)
+			r.Get("/{id}", jh.GetJob)
+			r.Post("/{id}/cancel", jh.CancelJob)
+		})
+	}
+
 	return r
 }
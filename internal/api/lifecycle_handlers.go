@@ -0,0 +1,105 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/pkg/errs"
+	"github.com/valu/encrpytion/pkg/jsn"
+)
+
+const (
+	defaultPendingWindowDays = 30
+	minPendingWindowDays     = 1
+	maxPendingWindowDays     = 365
+)
+
+func (h *KeyHandler) DisableKeyVersion(w http.ResponseWriter, r *http.Request) {
+	h.transitionKeyVersion(w, r, "disabled", func(name string, version int) (*model.EncryptionKey, error) {
+		return h.db.DisableKeyVersion(r.Context(), name, version)
+	})
+}
+
+func (h *KeyHandler) EnableKeyVersion(w http.ResponseWriter, r *http.Request) {
+	h.transitionKeyVersion(w, r, "enabled", func(name string, version int) (*model.EncryptionKey, error) {
+		return h.db.EnableKeyVersion(r.Context(), name, version)
+	})
+}
+
+// ScheduleKeyVersionDeletion disables a version right away and allows it to be destroyed
+// once the pending window has passed.
+func (h *KeyHandler) ScheduleKeyVersionDeletion(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PendingWindowDays int `json:"pending_window_days"`
+	}
+	if r.ContentLength != 0 {
+		if err := jsn.ReadJSON(w, r, &req); err != nil {
+			errs.BadRequestResponse(w, r, err)
+			return
+		}
+	}
+	if req.PendingWindowDays == 0 {
+		req.PendingWindowDays = defaultPendingWindowDays
+	}
+	if req.PendingWindowDays < minPendingWindowDays || req.PendingWindowDays > maxPendingWindowDays {
+		errs.BadRequestResponse(w, r, fmt.Errorf("pending_window_days must be between %d and %d", minPendingWindowDays, maxPendingWindowDays))
+		return
+	}
+
+	deletionDate := time.Now().AddDate(0, 0, req.PendingWindowDays)
+	h.transitionKeyVersion(w, r, "scheduled for deletion", func(name string, version int) (*model.EncryptionKey, error) {
+		return h.db.ScheduleKeyVersionDeletion(r.Context(), name, version, deletionDate)
+	})
+}
+
+func (h *KeyHandler) CancelKeyVersionDeletion(w http.ResponseWriter, r *http.Request) {
+	h.transitionKeyVersion(w, r, "deletion cancelled", func(name string, version int) (*model.EncryptionKey, error) {
+		return h.db.CancelKeyVersionDeletion(r.Context(), name, version)
+	})
+}
+
+// DestroyKeyVersion erases the material of a version scheduled for deletion. Everything
+// encrypted under that version becomes unrecoverable.
+func (h *KeyHandler) DestroyKeyVersion(w http.ResponseWriter, r *http.Request) {
+	h.transitionKeyVersion(w, r, "destroyed", func(name string, version int) (*model.EncryptionKey, error) {
+		return h.db.DestroyKeyVersion(r.Context(), name, version, time.Now())
+	})
+}
+
+func (h *KeyHandler) transitionKeyVersion(w http.ResponseWriter, r *http.Request, action string,
+	transition func(name string, version int) (*model.EncryptionKey, error)) {
+	name := chi.URLParam(r, "name")
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil || version < 1 {
+		errs.BadRequestResponse(w, r, errors.New("version must be a positive integer"))
+		return
+	}
+
+	key, err := transition(name, version)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		errs.NotFoundResponse(w, r)
+		return
+	case errors.Is(err, model.ErrInvalidTransition), errors.Is(err, repository.ErrDeletionPending):
+		errs.ConflictResponse(w, r, err)
+		return
+	case err != nil:
+		h.log.Error().Err(err).Str("key_name", name).Int("version", version).Msg("Failed to change key version status")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+	h.keys.Invalidate(name)
+	h.log.Info().Str("key_name", name).Int("version", version).Str("status", key.Status).Msg("Key version " + action)
+
+	if err := jsn.WriteJSON(w, http.StatusOK, key, nil); err != nil {
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
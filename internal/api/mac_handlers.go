@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/pkg/crypto"
+	"github.com/valu/encrpytion/pkg/errs"
+	"github.com/valu/encrpytion/pkg/jsn"
+)
+
+// GenerateMAC returns the HMAC of a message under the primary version of a mac key, for
+// blind indexes and webhook signatures. The MAC is tagged with the version, "v3:BASE64".
+func (h *CryptoHandler) GenerateMAC(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		KeyName string `json:"key_name"`
+		Message string `json:"message"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	currentKey, err := h.primaryKey(w, r, req.KeyName, model.KeyPurposeMAC)
+	if err != nil {
+		return
+	}
+
+	mac, err := crypto.GenerateMAC([]byte(req.Message), currentKey)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to generate MAC")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	response := struct {
+		MAC        string `json:"mac"`
+		KeyName    string `json:"key_name"`
+		KeyVersion int    `json:"key_version"`
+	}{
+		MAC:        mac,
+		KeyName:    currentKey.Name,
+		KeyVersion: currentKey.Version,
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// VerifyMAC checks a tagged MAC against the key version it names, so MACs of rotated
+// versions keep verifying until the version is disabled. A MAC that does not match, or
+// names a version that does not exist, is reported as invalid rather than as an error.
+func (h *CryptoHandler) VerifyMAC(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		KeyName string `json:"key_name"`
+		Message string `json:"message"`
+		MAC     string `json:"mac"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	if req.KeyName == "" {
+		errs.BadRequestResponse(w, r, errors.New("key_name must be provided"))
+		return
+	}
+
+	version, mac, err := crypto.ParseMACTag(req.MAC)
+	if err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	masterKey, err := h.versionKey(w, r, req.KeyName, version, model.KeyPurposeMAC)
+	if err != nil {
+		return
+	}
+	valid := false
+	if masterKey != nil {
+		valid, err = crypto.VerifyMAC([]byte(req.Message), mac, masterKey)
+		if err != nil {
+			h.log.Error().Err(err).Msg("Failed to verify MAC")
+			errs.ServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	response := struct {
+		Valid      bool   `json:"valid"`
+		KeyName    string `json:"key_name"`
+		KeyVersion int    `json:"key_version"`
+	}{
+		Valid:      valid,
+		KeyName:    req.KeyName,
+		KeyVersion: version,
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/valu/encrpytion/pkg/crypto"
+	"github.com/valu/encrpytion/pkg/errs"
+	"github.com/valu/encrpytion/pkg/jsn"
+)
+
+// Rewrap moves an envelope to the primary version of the key it was encrypted with.
+// The data key is unwrapped and wrapped again server-side, the plaintext is never exposed.
+func (h *CryptoHandler) Rewrap(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		KeyName           string                   `json:"key_name"`
+		Ciphertext        string                   `json:"ciphertext"`
+		EncryptionContext crypto.EncryptionContext `json:"encryption_context"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	envelope, oldKey, err := h.envelopeKey(w, r, req.KeyName, req.Ciphertext)
+	if err != nil {
+		return
+	}
+
+	currentKey, err := h.primaryKey(w, r, oldKey.Name, oldKey.Suite().Purpose)
+	if err != nil {
+		return
+	}
+
+	ciphertext, err := crypto.RewrapEnvelope(envelope, req.EncryptionContext, oldKey, currentKey)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to rewrap ciphertext")
+		errs.BadRequestResponse(w, r, errors.New("rewrap failed, the ciphertext or encryption context is invalid"))
+		return
+	}
+
+	response := struct {
+		Ciphertext    string `json:"ciphertext"`
+		KeyName       string `json:"key_name"`
+		KeyID         string `json:"key_id"`
+		OldKeyVersion int    `json:"old_key_version"`
+		NewKeyVersion int    `json:"new_key_version"`
+	}{
+		Ciphertext:    base64.StdEncoding.EncodeToString(ciphertext),
+		KeyName:       currentKey.Name,
+		KeyID:         currentKey.KeyID.String(),
+		OldKeyVersion: oldKey.Version,
+		NewKeyVersion: currentKey.Version,
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/pkg/crypto"
+	"github.com/valu/encrpytion/pkg/errs"
+	"github.com/valu/encrpytion/pkg/jsn"
+)
+
+// signedData is what a sign or verify request signs: message, message_b64 for binary
+// data, or digest for data the caller hashed with the hash of the key's suite. At most
+// one of them may be provided.
+type signedData struct {
+	Message    string `json:"message"`
+	MessageB64 string `json:"message_b64"`
+	Digest     string `json:"digest"`
+}
+
+// decode returns the data to sign and whether it is a digest rather than the message.
+func (d signedData) decode() ([]byte, bool, error) {
+	provided := 0
+	for _, field := range []string{d.Message, d.MessageB64, d.Digest} {
+		if field != "" {
+			provided++
+		}
+	}
+	if provided > 1 {
+		return nil, false, errors.New("only one of message, message_b64 and digest may be provided")
+	}
+
+	switch {
+	case d.MessageB64 != "":
+		message, err := base64.StdEncoding.DecodeString(d.MessageB64)
+		if err != nil {
+			return nil, false, errors.New("message_b64 must be base64 encoded")
+		}
+		return message, false, nil
+	case d.Digest != "":
+		digest, err := base64.StdEncoding.DecodeString(d.Digest)
+		if err != nil {
+			return nil, false, errors.New("digest must be base64 encoded")
+		}
+		return digest, true, nil
+	default:
+		return []byte(d.Message), false, nil
+	}
+}
+
+// Sign signs a message with the private half of the primary version of a sign key. The
+// private half never leaves the service, verifiers use the exported public key.
+func (h *CryptoHandler) Sign(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		KeyName string `json:"key_name"`
+		signedData
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	data, isDigest, err := req.decode()
+	if err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	currentKey, err := h.primaryKey(w, r, req.KeyName, model.KeyPurposeSign)
+	if err != nil {
+		return
+	}
+
+	var signature []byte
+	if isDigest {
+		signature, err = crypto.SignDigest(data, currentKey)
+	} else {
+		signature, err = crypto.Sign(data, currentKey)
+	}
+	switch {
+	case errors.Is(err, crypto.ErrInvalidDigest):
+		errs.BadRequestResponse(w, r, err)
+		return
+	case err != nil:
+		h.log.Error().Err(err).Msg("Failed to sign message")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	response := struct {
+		Signature  string `json:"signature"`
+		KeyName    string `json:"key_name"`
+		KeyID      string `json:"key_id"`
+		KeyVersion int    `json:"key_version"`
+		Algorithm  string `json:"algorithm"`
+	}{
+		Signature:  base64.StdEncoding.EncodeToString(signature),
+		KeyName:    currentKey.Name,
+		KeyID:      currentKey.KeyID.String(),
+		KeyVersion: currentKey.Version,
+		Algorithm:  currentKey.Algorithm,
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Verify checks a signature against the key version that made it. Like VerifyMAC, a
+// signature that does not match or names an unknown version is reported as invalid.
+func (h *CryptoHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		KeyName    string `json:"key_name"`
+		KeyVersion int    `json:"key_version"`
+		Signature  string `json:"signature"`
+		signedData
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	data, isDigest, err := req.decode()
+	if err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	if req.KeyName == "" || req.KeyVersion < 1 {
+		errs.BadRequestResponse(w, r, errors.New("key_name and key_version must be provided"))
+		return
+	}
+	signature, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil {
+		errs.BadRequestResponse(w, r, errors.New("signature must be base64 encoded"))
+		return
+	}
+
+	masterKey, err := h.versionKey(w, r, req.KeyName, req.KeyVersion, model.KeyPurposeSign)
+	if err != nil {
+		return
+	}
+	valid := false
+	if masterKey != nil {
+		if isDigest {
+			valid, err = crypto.VerifyDigest(data, signature, masterKey)
+		} else {
+			valid, err = crypto.Verify(data, signature, masterKey)
+		}
+		switch {
+		case errors.Is(err, crypto.ErrInvalidDigest):
+			errs.BadRequestResponse(w, r, err)
+			return
+		case err != nil:
+			h.log.Error().Err(err).Msg("Failed to verify signature")
+			errs.ServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	response := struct {
+		Valid      bool   `json:"valid"`
+		KeyName    string `json:"key_name"`
+		KeyVersion int    `json:"key_version"`
+	}{
+		Valid:      valid,
+		KeyName:    req.KeyName,
+		KeyVersion: req.KeyVersion,
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/pkg/crypto"
+	"github.com/valu/encrpytion/pkg/errs"
+)
+
+// Streaming endpoints take the payload as the raw application/octet-stream body, so it
+// is not bound by the JSON body limit and is never held in memory as a whole. The key
+// name is the key_name query parameter and the encryption context, a JSON object, the
+// X-Encryption-Context header. Key details are returned in response headers.
+const encryptionContextHeader = "X-Encryption-Context"
+
+// EncryptStream encrypts the request body into the segmented stream format of
+// crypto.NewEncryptWriter under the primary version of an encrypt key.
+func (h *CryptoHandler) EncryptStream(w http.ResponseWriter, r *http.Request) {
+	encryptionContext, err := readStreamRequest(w, r)
+	if err != nil {
+		return
+	}
+	currentKey, err := h.primaryKey(w, r, r.URL.Query().Get("key_name"), model.KeyPurposeEncrypt)
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	setKeyHeaders(w, currentKey)
+	encrypter, err := crypto.NewEncryptWriter(w, encryptionContext, currentKey)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to encrypt stream")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+	if _, err := io.Copy(encrypter, r.Body); err != nil {
+		h.abortStream(err, "Failed to encrypt stream")
+	}
+	if err := encrypter.Close(); err != nil {
+		h.abortStream(err, "Failed to encrypt stream")
+	}
+}
+
+// DecryptStream decrypts a stream made by EncryptStream. The first segment is opened
+// before the response starts so that a wrong key or encryption context is reported as
+// an error. A segment that fails later aborts the response, clients must treat a body
+// that ends without a clean end of the response as failed.
+func (h *CryptoHandler) DecryptStream(w http.ResponseWriter, r *http.Request) {
+	encryptionContext, err := readStreamRequest(w, r)
+	if err != nil {
+		return
+	}
+
+	header, err := crypto.ReadStreamHeader(r.Body)
+	if err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	masterKey, err := h.decryptionKey(w, r, r.URL.Query().Get("key_name"), header.KeyID)
+	if err != nil {
+		return
+	}
+
+	plaintext, err := crypto.NewDecryptReader(r.Body, header, encryptionContext, masterKey)
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to decrypt stream")
+		errs.BadRequestResponse(w, r, errors.New("decryption failed, the ciphertext or encryption context is invalid"))
+		return
+	}
+	first := make([]byte, crypto.StreamSegmentSize)
+	n, err := io.ReadFull(plaintext, first)
+	if errors.Is(err, crypto.ErrStreamTruncated) {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		h.log.Error().Err(err).Msg("Failed to decrypt stream")
+		errs.BadRequestResponse(w, r, errors.New("decryption failed, the ciphertext or encryption context is invalid"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	setKeyHeaders(w, masterKey)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(first[:n]); err != nil {
+		h.abortStream(err, "Failed to write response")
+	}
+	if _, err := io.Copy(w, plaintext); err != nil {
+		h.abortStream(err, "Failed to decrypt stream")
+	}
+}
+
+// readStreamRequest checks the content type and parses the encryption context header.
+// The request body is read while the response is written, which HTTP/1.x only allows
+// in full duplex mode. Handlers read from the body before they write, a client waiting
+// for 100 Continue would otherwise never send it. On error the response has already
+// been written.
+func readStreamRequest(w http.ResponseWriter, r *http.Request) (crypto.EncryptionContext, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/octet-stream" {
+		err := errors.New("body must be application/octet-stream")
+		errs.SendErrorResponse(w, r, http.StatusUnsupportedMediaType, err.Error())
+		return nil, err
+	}
+
+	var encryptionContext crypto.EncryptionContext
+	if value := r.Header.Get(encryptionContextHeader); value != "" {
+		if err := json.Unmarshal([]byte(value), &encryptionContext); err != nil {
+			err := errors.New(encryptionContextHeader + " must be a JSON object of strings")
+			errs.BadRequestResponse(w, r, err)
+			return nil, err
+		}
+	}
+
+	// HTTP/2 is always full duplex and reports that as not supported.
+	_ = http.NewResponseController(w).EnableFullDuplex()
+	return encryptionContext, nil
+}
+
+func setKeyHeaders(w http.ResponseWriter, key *crypto.MasterKey) {
+	w.Header().Set("X-Key-Name", key.Name)
+	w.Header().Set("X-Key-Id", key.KeyID.String())
+	w.Header().Set("X-Key-Version", strconv.Itoa(key.Version))
+}
+
+// abortStream ends a response whose status has already been sent. Aborting, rather
+// than returning, keeps a partial body from looking like a complete one.
+func (h *CryptoHandler) abortStream(err error, msg string) {
+	h.log.Error().Err(err).Msg(msg)
+	panic(http.ErrAbortHandler)
+}
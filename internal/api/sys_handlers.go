@@ -0,0 +1,227 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/auth"
+	"github.com/valu/encrpytion/internal/keycache"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/seal"
+	"github.com/valu/encrpytion/pkg/errs"
+	"github.com/valu/encrpytion/pkg/jsn"
+)
+
+type SysHandler struct {
+	barrier *seal.Barrier
+	keys    *keycache.Cache
+	authn   *auth.Authenticator
+	// bootstrapSecret, when set, must be presented as the bearer token of Init, which then
+	// also issues the root API client.
+	bootstrapSecret string
+	log             *zerolog.Logger
+}
+
+// Init splits a new root key into key shares. With a bootstrap secret configured it
+// requires that secret and also issues the root API client, which holds every scope and
+// whose token is only returned here. The client is issued before the keystore is
+// initialized, so a failure leaves the keystore uninitialized and Init can be retried.
+// Without a bootstrap secret the first client is issued with the clients subcommand.
+func (h *SysHandler) Init(w http.ResponseWriter, r *http.Request) {
+	if h.bootstrapSecret != "" && !h.validBootstrapSecret(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		errs.SendErrorResponse(w, r, http.StatusUnauthorized, "the bootstrap secret must be provided as a bearer token")
+		return
+	}
+
+	var req struct {
+		SecretShares    int `json:"secret_shares"`
+		SecretThreshold int `json:"secret_threshold"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	switch {
+	case req.SecretThreshold < 2:
+		errs.BadRequestResponse(w, r, errors.New("secret_threshold must be at least 2"))
+		return
+	case req.SecretShares < req.SecretThreshold:
+		errs.BadRequestResponse(w, r, errors.New("secret_shares must not be less than secret_threshold"))
+		return
+	case req.SecretShares > 255:
+		errs.BadRequestResponse(w, r, errors.New("secret_shares must not exceed 255"))
+		return
+	}
+
+	status, err := h.barrier.Status(r.Context())
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to get seal status")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+	if status.Initialized {
+		errs.ConflictResponse(w, r, seal.ErrAlreadyInitialized)
+		return
+	}
+
+	var root *model.APIClient
+	var rootToken string
+	if h.bootstrapSecret != "" {
+		root, rootToken, err = h.authn.Issue(r.Context(), "root", model.AllScopes)
+		if err != nil {
+			h.log.Error().Err(err).Msg("Failed to issue the root API client")
+			errs.ServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	shares, err := h.barrier.Init(r.Context(), req.SecretShares, req.SecretThreshold)
+	if err != nil && root != nil {
+		// Lost a race with another Init or failed, the root token is never returned.
+		if _, err := h.authn.Revoke(r.Context(), root.ID); err != nil {
+			h.log.Error().Err(err).Str("client_id", root.ID.String()).Msg("Failed to revoke the unused root API client")
+		}
+	}
+	if errors.Is(err, seal.ErrAlreadyInitialized) {
+		errs.ConflictResponse(w, r, err)
+		return
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to initialize keystore")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	encodedShares := make([]string, len(shares))
+	for i, share := range shares {
+		encodedShares[i] = base64.StdEncoding.EncodeToString(share)
+	}
+
+	response := struct {
+		Keys            []string `json:"keys"`
+		SecretShares    int      `json:"secret_shares"`
+		SecretThreshold int      `json:"secret_threshold"`
+		RootToken       string   `json:"root_token,omitempty"`
+	}{
+		Keys:            encodedShares,
+		SecretShares:    req.SecretShares,
+		SecretThreshold: req.SecretThreshold,
+		RootToken:       rootToken,
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, response, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Unseal takes one key share at a time, share holders need no API token. A failed
+// unseal keeps the shares submitted so far, so a bad share stalls the unseal but cannot
+// discard the progress of the others. Only reset discards them, it requires the
+// keys:admin scope.
+func (h *SysHandler) Unseal(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key   string `json:"key"`
+		Reset bool   `json:"reset"`
+	}
+	if err := jsn.ReadJSON(w, r, &req); err != nil {
+		errs.BadRequestResponse(w, r, err)
+		return
+	}
+
+	if req.Reset {
+		client := clientFromContext(r.Context())
+		if client == nil {
+			errs.UnauthorizedResponse(w, r)
+			return
+		}
+		if !client.HasScope(model.ScopeKeysAdmin) {
+			errs.ForbiddenResponse(w, r, fmt.Errorf("the API client lacks the %s scope", model.ScopeKeysAdmin))
+			return
+		}
+		h.barrier.ResetUnseal()
+		h.SealStatus(w, r)
+		return
+	}
+
+	share, err := base64.StdEncoding.DecodeString(req.Key)
+	if err != nil {
+		errs.BadRequestResponse(w, r, errors.New("key must be a base64 encoded key share"))
+		return
+	}
+
+	status, err := h.barrier.Unseal(r.Context(), share)
+	switch {
+	case errors.Is(err, seal.ErrNotInitialized), errors.Is(err, seal.ErrInvalidShares), errors.Is(err, seal.ErrMalformedShare):
+		errs.BadRequestResponse(w, r, err)
+		return
+	case errors.Is(err, seal.ErrAlreadyUnsealed), errors.Is(err, seal.ErrTooManyShares):
+		errs.ConflictResponse(w, r, err)
+		return
+	case err != nil:
+		h.log.Error().Err(err).Msg("Failed to unseal keystore")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, status, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (h *SysHandler) Seal(w http.ResponseWriter, r *http.Request) {
+	h.barrier.Seal()
+	h.keys.Purge()
+	h.SealStatus(w, r)
+}
+
+func (h *SysHandler) SealStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.barrier.Status(r.Context())
+	if err != nil {
+		h.log.Error().Err(err).Msg("Failed to get seal status")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err := jsn.WriteJSON(w, http.StatusOK, status, nil); err != nil {
+		h.log.Error().Err(err).Msg("Failed to write response")
+		errs.ServerErrorResponse(w, r, err)
+		return
+	}
+}
+
+// validBootstrapSecret compares the digests of the bearer token and the secret, so the
+// comparison takes the same time whatever their lengths.
+func (h *SysHandler) validBootstrapSecret(r *http.Request) bool {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	got := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	want := sha256.Sum256([]byte(h.bootstrapSecret))
+	return subtle.ConstantTimeCompare(got[:], want[:]) == 1
+}
+
+// requireUnsealed rejects every request with 503 while the keystore is sealed.
+func requireUnsealed(barrier *seal.Barrier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if barrier.Sealed() {
+				errs.SealedResponse(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
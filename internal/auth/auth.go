@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+)
+
+// DefaultTTL bounds how long a verified token or certificate identity is accepted without
+// looking at the store again, and so how long a revocation by another replica takes to
+// apply.
+const DefaultTTL = time.Minute
+
+// maxCachedClients bounds the cache, expired entries are dropped once it is reached.
+const maxCachedClients = 10000
+
+// Authenticator issues, verifies and revokes API clients. Verifying a token runs
+// argon2id, which is deliberately slow, so verified tokens are cached by their SHA-256
+// digest for the TTL, and certificate identities by value. Failed verifications are
+// never cached.
+type Authenticator struct {
+	db  repository.KeyStore
+	ttl time.Duration
+
+	mu    sync.Mutex
+	cache map[cacheKey]cachedClient
+}
+
+// cacheKey holds either the digest of a token or a certificate identity.
+type cacheKey struct {
+	tokenDigest [sha256.Size]byte
+	identity    string
+}
+
+type cachedClient struct {
+	client  *model.APIClient
+	expires time.Time
+}
+
+func NewAuthenticator(db repository.KeyStore, ttl time.Duration) *Authenticator {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Authenticator{
+		db:    db,
+		ttl:   ttl,
+		cache: make(map[cacheKey]cachedClient),
+	}
+}
+
+// Issue creates a client with the given scopes and returns it with its token. The token
+// cannot be recovered later, only its hash is stored.
+func (a *Authenticator) Issue(ctx context.Context, name string, scopes []model.Scope) (*model.APIClient, string, error) {
+	if err := model.ValidateClient(name, scopes); err != nil {
+		return nil, "", err
+	}
+
+	client := &model.APIClient{
+		ID:           uuid.New(),
+		Name:         name,
+		Scopes:       scopes,
+		CreationDate: time.Now().UTC(),
+	}
+	token, hash, err := newToken(client.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	client.SecretHash = hash
+
+	if err := a.db.CreateAPIClient(ctx, client); err != nil {
+		return nil, "", err
+	}
+	return client, token, nil
+}
+
+// IssueCertificateClient creates a client that authenticates with TLS client certificates
+// of the given identity, see CertificateIdentity. It has no token. It returns
+// repository.ErrIdentityTaken when the identity is bound to a client that is not revoked.
+func (a *Authenticator) IssueCertificateClient(ctx context.Context, name, identity string, scopes []model.Scope) (*model.APIClient, error) {
+	if err := model.ValidateClient(name, scopes); err != nil {
+		return nil, err
+	}
+	if err := model.ValidateCertificateIdentity(identity); err != nil {
+		return nil, err
+	}
+
+	client := &model.APIClient{
+		ID:                  uuid.New(),
+		Name:                name,
+		Scopes:              scopes,
+		CertificateIdentity: identity,
+		CreationDate:        time.Now().UTC(),
+	}
+	if err := a.db.CreateAPIClient(ctx, client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Authenticate returns the client a token belongs to. It returns ErrInvalidToken for
+// any token that must be refused.
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (*model.APIClient, error) {
+	key := cacheKey{tokenDigest: sha256.Sum256([]byte(token))}
+	if client := a.cached(key); client != nil {
+		return client, nil
+	}
+
+	id, secret, err := parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	client, err := a.db.GetAPIClient(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.Revoked() || client.SecretHash == "" {
+		return nil, ErrInvalidToken
+	}
+	valid, err := verifySecret(secret, client.SecretHash)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrInvalidToken
+	}
+
+	a.store(key, client)
+	return client, nil
+}
+
+// AuthenticateCertificate returns the client bound to the identity of a verified client
+// certificate. It returns ErrUnknownIdentity when no client that is not revoked is bound
+// to it.
+func (a *Authenticator) AuthenticateCertificate(ctx context.Context, identity string) (*model.APIClient, error) {
+	key := cacheKey{identity: identity}
+	if client := a.cached(key); client != nil {
+		return client, nil
+	}
+
+	client, err := a.db.GetAPIClientByIdentity(ctx, identity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnknownIdentity
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.store(key, client)
+	return client, nil
+}
+
+// Revoke revokes a client and drops it from the cache, so this replica refuses it at
+// once. It returns sql.ErrNoRows when the client does not exist.
+func (a *Authenticator) Revoke(ctx context.Context, id uuid.UUID) (*model.APIClient, error) {
+	client, err := a.db.RevokeAPIClient(ctx, id, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	for key, cached := range a.cache {
+		if cached.client.ID == id {
+			delete(a.cache, key)
+		}
+	}
+	a.mu.Unlock()
+	return client, nil
+}
+
+func (a *Authenticator) cached(key cacheKey) *model.APIClient {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cached, ok := a.cache[key]
+	if !ok || !time.Now().Before(cached.expires) {
+		return nil
+	}
+	return cached.client
+}
+
+func (a *Authenticator) store(key cacheKey, client *model.APIClient) {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.cache) >= maxCachedClients {
+		for key, cached := range a.cache {
+			if !now.Before(cached.expires) {
+				delete(a.cache, key)
+			}
+		}
+	}
+	if len(a.cache) < maxCachedClients {
+		a.cache[key] = cachedClient{client: client, expires: now.Add(a.ttl)}
+	}
+}
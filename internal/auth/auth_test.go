@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/repository/memory"
+)
+
+func TestAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	authn := NewAuthenticator(memory.New(), 0)
+
+	client, token, err := authn.Issue(ctx, "billing", []model.Scope{model.ScopeCryptoEncrypt})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if client.SecretHash == "" || client.SecretHash == token {
+		t.Fatal("Issue did not store a hash of the token")
+	}
+
+	got, err := authn.Authenticate(ctx, token)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if got.ID != client.ID {
+		t.Fatalf("Authenticate returned client %s, want %s", got.ID, client.ID)
+	}
+	if !got.HasScope(model.ScopeCryptoEncrypt) || got.HasScope(model.ScopeCryptoDecrypt) {
+		t.Fatalf("client scopes = %v, want only %s", got.Scopes, model.ScopeCryptoEncrypt)
+	}
+
+	// A token for the right client with another secret must not pass, cached or not.
+	_, otherToken, err := authn.Issue(ctx, "other", []model.Scope{model.ScopeCryptoEncrypt})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	_, otherSecret, _ := parseToken(otherToken)
+	forged := tokenPrefix + hex.EncodeToString(client.ID[:]) + "_" + otherSecret
+	if _, err := authn.Authenticate(ctx, forged); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Authenticate of a forged token = %v, want ErrInvalidToken", err)
+	}
+	unknown := uuid.New()
+	if _, err := authn.Authenticate(ctx, tokenPrefix+hex.EncodeToString(unknown[:])+"_"+otherSecret); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Authenticate for an unknown client = %v, want ErrInvalidToken", err)
+	}
+
+	if _, err := authn.Revoke(ctx, client.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := authn.Authenticate(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Authenticate after revoke = %v, want ErrInvalidToken", err)
+	}
+	if _, err := authn.Revoke(ctx, uuid.New()); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Revoke of an unknown client = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestIssueValidatesClient(t *testing.T) {
+	authn := NewAuthenticator(memory.New(), 0)
+	if _, _, err := authn.Issue(context.Background(), "billing", nil); err == nil {
+		t.Fatal("Issue accepted a client without scopes")
+	}
+	if _, _, err := authn.Issue(context.Background(), "billing", []model.Scope{"crypto:everything"}); err == nil {
+		t.Fatal("Issue accepted an unknown scope")
+	}
+	if _, _, err := authn.Issue(context.Background(), "bad name", []model.Scope{model.ScopeCryptoEncrypt}); err == nil {
+		t.Fatal("Issue accepted an invalid client name")
+	}
+}
+
+func TestAuthenticateCertificate(t *testing.T) {
+	ctx := context.Background()
+	authn := NewAuthenticator(memory.New(), 0)
+	identity := "spiffe://acme.internal/ns/prod/sa/billing"
+
+	client, err := authn.IssueCertificateClient(ctx, "billing", identity, []model.Scope{model.ScopeCryptoDecrypt})
+	if err != nil {
+		t.Fatalf("IssueCertificateClient: %v", err)
+	}
+	if _, err := authn.IssueCertificateClient(ctx, "billing2", identity, []model.Scope{model.ScopeCryptoDecrypt}); !errors.Is(err, repository.ErrIdentityTaken) {
+		t.Fatalf("IssueCertificateClient of a bound identity = %v, want ErrIdentityTaken", err)
+	}
+
+	got, err := authn.AuthenticateCertificate(ctx, identity)
+	if err != nil {
+		t.Fatalf("AuthenticateCertificate: %v", err)
+	}
+	if got.ID != client.ID {
+		t.Fatalf("AuthenticateCertificate returned client %s, want %s", got.ID, client.ID)
+	}
+	if _, err := authn.AuthenticateCertificate(ctx, "CN=billing,O=Acme"); !errors.Is(err, ErrUnknownIdentity) {
+		t.Fatalf("AuthenticateCertificate of an unbound identity = %v, want ErrUnknownIdentity", err)
+	}
+	// A certificate client has no secret, no token may authenticate as it.
+	forged := tokenPrefix + hex.EncodeToString(client.ID[:]) + "_secret"
+	if _, err := authn.Authenticate(ctx, forged); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Authenticate as a certificate client = %v, want ErrInvalidToken", err)
+	}
+
+	// Revoking drops the cached identity and frees it for a new client.
+	if _, err := authn.Revoke(ctx, client.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := authn.AuthenticateCertificate(ctx, identity); !errors.Is(err, ErrUnknownIdentity) {
+		t.Fatalf("AuthenticateCertificate after revoke = %v, want ErrUnknownIdentity", err)
+	}
+	if _, err := authn.IssueCertificateClient(ctx, "billing2", identity, []model.Scope{model.ScopeCryptoDecrypt}); err != nil {
+		t.Fatalf("IssueCertificateClient of a revoked identity: %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+// ErrUnknownIdentity is returned for a verified client certificate whose identity is not
+// bound to an API client.
+var ErrUnknownIdentity = errors.New("certificate identity is not bound to an API client")
+
+// CertificateIdentity returns the identity of a verified client certificate: its SPIFFE
+// ID when it has a spiffe:// URI SAN, as workload certificates issued by SPIRE do, and
+// its subject, such as CN=billing,O=Acme, otherwise.
+func CertificateIdentity(cert *x509.Certificate) string {
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.String()
+		}
+	}
+	return cert.Subject.String()
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestCertificateIdentity(t *testing.T) {
+	subject := pkix.Name{CommonName: "billing", Organization: []string{"Acme"}}
+	spiffe, _ := url.Parse("spiffe://acme.internal/ns/prod/sa/billing")
+	other, _ := url.Parse("https://billing.acme.internal")
+
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want string
+	}{
+		{"subject", &x509.Certificate{Subject: subject}, "CN=billing,O=Acme"},
+		{"non-spiffe uri", &x509.Certificate{Subject: subject, URIs: []*url.URL{other}}, "CN=billing,O=Acme"},
+		{"spiffe id", &x509.Certificate{Subject: subject, URIs: []*url.URL{other, spiffe}}, "spiffe://acme.internal/ns/prod/sa/billing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CertificateIdentity(tt.cert); got != tt.want {
+				t.Fatalf("CertificateIdentity() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
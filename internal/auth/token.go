@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/argon2"
+)
+
+// Tokens are "ek_" followed by the hex client id, "_" and a 256-bit random secret in
+// unpadded base64url. The client id finds the stored hash without scanning every client.
+const tokenPrefix = "ek_"
+
+const secretSize = 32
+
+// argon2id parameters of new hashes, the OWASP recommendation of 19 MiB, two passes and
+// one lane. Stored hashes carry their own parameters, so these can be raised later.
+const (
+	argonMemory  = 19 * 1024
+	argonTime    = 2
+	argonThreads = 1
+	argonKeySize = 32
+	argonSalt    = 16
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, name an unknown or revoked
+// client or have a wrong secret. Callers do not learn which.
+var ErrInvalidToken = errors.New("invalid API token")
+
+// newToken returns a fresh token for the client and the argon2id hash to store for it.
+func newToken(clientID uuid.UUID) (string, string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+	hash, err := hashSecret(encodedSecret)
+	if err != nil {
+		return "", "", err
+	}
+	return tokenPrefix + hex.EncodeToString(clientID[:]) + "_" + encodedSecret, hash, nil
+}
+
+// parseToken splits a token into the client id and the secret.
+func parseToken(token string) (uuid.UUID, string, error) {
+	rest, ok := strings.CutPrefix(token, tokenPrefix)
+	if !ok {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	// The hex id has no underscore, the base64url secret may.
+	encodedID, secret, ok := strings.Cut(rest, "_")
+	if !ok || secret == "" {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	id, err := hex.DecodeString(encodedID)
+	if err != nil || len(id) != len(uuid.Nil) {
+		return uuid.Nil, "", ErrInvalidToken
+	}
+	return uuid.UUID(id), secret, nil
+}
+
+// hashSecret returns the argon2id hash of secret in the PHC string format,
+// $argon2id$v=19$m=19456,t=2,p=1$salt$hash.
+func hashSecret(secret string) (string, error) {
+	salt := make([]byte, argonSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash := argon2.IDKey([]byte(secret), salt, argonTime, argonMemory, argonThreads, argonKeySize)
+	b64 := base64.RawStdEncoding.EncodeToString
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads, b64(salt), b64(hash)), nil
+}
+
+// verifySecret reports whether secret matches a hash made by hashSecret.
+func verifySecret(secret, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, errors.New("stored secret hash is not an argon2id hash")
+	}
+	var version int
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, fmt.Errorf("malformed argon2 parameters %q", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, err
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, err
+	}
+
+	got := argon2.IDKey([]byte(secret), salt, time, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTokenRoundTrip(t *testing.T) {
+	clientID := uuid.New()
+	token, hash, err := newToken(clientID)
+	if err != nil {
+		t.Fatalf("newToken: %v", err)
+	}
+	if !strings.HasPrefix(token, tokenPrefix) {
+		t.Fatalf("token %q lacks the %q prefix", token, tokenPrefix)
+	}
+
+	id, secret, err := parseToken(token)
+	if err != nil {
+		t.Fatalf("parseToken: %v", err)
+	}
+	if id != clientID {
+		t.Fatalf("parsed client id %s, want %s", id, clientID)
+	}
+	valid, err := verifySecret(secret, hash)
+	if err != nil {
+		t.Fatalf("verifySecret: %v", err)
+	}
+	if !valid {
+		t.Fatal("the token's secret does not verify against its hash")
+	}
+}
+
+func TestParseTokenRefusesMalformed(t *testing.T) {
+	id := strings.ReplaceAll(uuid.NewString(), "-", "")
+	tokens := map[string]string{
+		"empty":          "",
+		"wrong prefix":   "xk_" + id + "_secret",
+		"no secret":      tokenPrefix + id,
+		"empty secret":   tokenPrefix + id + "_",
+		"short id":       tokenPrefix + id[:30] + "_secret",
+		"id is not hex":  tokenPrefix + strings.Repeat("z", 32) + "_secret",
+		"dashed uuid id": tokenPrefix + uuid.NewString() + "_secret",
+	}
+	for name, token := range tokens {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := parseToken(token); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("parseToken(%q) = %v, want ErrInvalidToken", token, err)
+			}
+		})
+	}
+}
+
+func TestVerifySecret(t *testing.T) {
+	hash, err := hashSecret("secret")
+	if err != nil {
+		t.Fatalf("hashSecret: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Fatalf("hash %q is not in the expected PHC format", hash)
+	}
+	if valid, err := verifySecret("secret", hash); err != nil || !valid {
+		t.Fatalf("verifySecret of the right secret = %v, %v, want true", valid, err)
+	}
+	if valid, err := verifySecret("Secret", hash); err != nil || valid {
+		t.Fatalf("verifySecret of a wrong secret = %v, %v, want false", valid, err)
+	}
+
+	// The parameters come from the stored hash, not from the current constants.
+	parts := strings.Split(hash, "$")
+	parts[3] = "m=8192,t=1,p=1"
+	if valid, err := verifySecret("secret", strings.Join(parts, "$")); err != nil || valid {
+		t.Fatalf("verifySecret with altered parameters = %v, %v, want false", valid, err)
+	}
+
+	for _, malformed := range []string{"", "$2a$10$abc", "$argon2i$v=19$m=19456,t=2,p=1$c2FsdA$aGFzaA", "$argon2id$v=16$m=19456,t=2,p=1$c2FsdA$aGFzaA"} {
+		if _, err := verifySecret("secret", malformed); err == nil {
+			t.Errorf("verifySecret accepted the malformed hash %q", malformed)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Package jobs runs long-lived rewrap jobs that move stored envelopes to the primary
+// version of their key, so old key versions can eventually be retired.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/seal"
+	"github.com/valu/encrpytion/pkg/crypto"
+)
+
+const (
+	// pollInterval is how often the runner looks for claimable jobs.
+	pollInterval = 5 * time.Second
+	// leaseDuration is how long a job stays with a runner without a checkpoint
+	// before another replica may take it over.
+	leaseDuration = time.Minute
+)
+
+var (
+	errJobStopped = errors.New("job was cancelled or taken over")
+	errKeyExpired = errors.New("primary key version has expired")
+)
+
+// Runner claims jobs from the rewrap_jobs table and executes them one at a time.
+// Several replicas can run a Runner against the same database.
+type Runner struct {
+	db      *repository.DB
+	barrier *seal.Barrier
+	log     *zerolog.Logger
+	owner   uuid.UUID
+}
+
+func NewRunner(db *repository.DB, barrier *seal.Barrier, log *zerolog.Logger) *Runner {
+	return &Runner{db: db, barrier: barrier, log: log, owner: uuid.New()}
+}
+
+// Run polls for jobs until ctx is cancelled. Nothing runs while the keystore is sealed.
+func (r *Runner) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if r.barrier.Sealed() {
+			continue
+		}
+
+		job, err := r.db.ClaimJob(ctx, r.owner, leaseDuration)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			r.log.Error().Err(err).Msg("Failed to claim rewrap job")
+			continue
+		}
+
+		r.execute(ctx, job)
+	}
+}
+
+func (r *Runner) execute(ctx context.Context, job *model.RewrapJob) {
+	log := r.log.With().Str("job_id", job.ID.String()).Str("key_name", job.KeyName).Logger()
+	log.Info().Str("checkpoint", job.Checkpoint).Msg("Starting rewrap job")
+
+	err := r.process(ctx, job)
+	switch {
+	case errors.Is(err, errJobStopped):
+		log.Info().Msg("Rewrap job stopped")
+		return
+	case errors.Is(err, seal.ErrSealed), errors.Is(err, errKeyExpired), errors.Is(err, context.Canceled):
+		// The lease runs out and the job is picked up again once the keystore is unsealed
+		// or the scheduler has rotated the expired key.
+		log.Warn().Err(err).Msg("Rewrap job interrupted")
+		return
+	case err != nil:
+		log.Error().Err(err).Msg("Rewrap job failed")
+		if err := r.db.FinishJob(ctx, job, r.owner, model.JobStatusFailed, err.Error()); err != nil {
+			log.Error().Err(err).Msg("Failed to record rewrap job failure")
+		}
+		return
+	}
+
+	if err := r.db.FinishJob(ctx, job, r.owner, model.JobStatusCompleted, ""); err != nil {
+		log.Error().Err(err).Msg("Failed to complete rewrap job")
+		return
+	}
+	log.Info().Int64("processed", job.Processed).Int64("rewrapped", job.Rewrapped).Int64("failed", job.Failed).
+		Msg("Rewrap job completed")
+}
+
+func (r *Runner) process(ctx context.Context, job *model.RewrapJob) error {
+	source, err := NewSource(ctx, r.db.DB, job)
+	if err != nil {
+		return err
+	}
+	encryptionContext := crypto.EncryptionContext(job.EncryptionContext)
+	// Old key versions are looked up once per job, not once per row.
+	oldKeys := make(map[uuid.UUID]*crypto.MasterKey)
+
+	for {
+		rootKey, err := r.barrier.RootKey()
+		if err != nil {
+			return err
+		}
+		// The primary version is read per batch so a rotation during the job is picked up.
+		current, err := r.db.GetCurrentActiveKey(ctx, job.KeyName)
+		if err != nil {
+			return err
+		}
+		if current.Expired(time.Now()) {
+			return errKeyExpired
+		}
+		target, err := crypto.UnwrapMasterKey(current, rootKey)
+		if err != nil {
+			return err
+		}
+
+		records, err := source.Next(ctx, job.Checkpoint, job.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		for _, record := range records {
+			rewrapped, err := r.rewrapRecord(ctx, source, record, job, target, oldKeys, encryptionContext, rootKey)
+			switch {
+			case err != nil:
+				job.Failed++
+				r.log.Debug().Err(err).Str("job_id", job.ID.String()).Str("record_id", record.ID).Msg("Failed to rewrap record")
+			case rewrapped:
+				job.Rewrapped++
+			}
+			job.Processed++
+			job.Checkpoint = record.ID
+		}
+
+		ok, err := r.db.CheckpointJob(ctx, job, r.owner, leaseDuration)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errJobStopped
+		}
+	}
+}
+
+// rewrapRecord rewraps one envelope if it belongs to the job's key and is older than
+// the job's version bound. It reports whether the record was rewritten.
+func (r *Runner) rewrapRecord(ctx context.Context, source Source, record Record, job *model.RewrapJob, target *crypto.MasterKey,
+	oldKeys map[uuid.UUID]*crypto.MasterKey, encryptionContext crypto.EncryptionContext, rootKey []byte) (bool, error) {
+	if record.Ciphertext == nil {
+		return false, errors.New("stored value is not decodable")
+	}
+	envelope, err := crypto.ParseEnvelope(record.Ciphertext)
+	if err != nil {
+		return false, err
+	}
+	if int(envelope.KeyVersion) >= job.BelowVersion || envelope.KeyID == target.KeyID {
+		return false, nil
+	}
+
+	oldKey, ok := oldKeys[envelope.KeyID]
+	if !ok {
+		key, err := r.db.GetKey(ctx, envelope.KeyID)
+		if err != nil {
+			return false, err
+		}
+		if oldKey, err = crypto.UnwrapMasterKey(key, rootKey); err != nil {
+			return false, err
+		}
+		oldKeys[envelope.KeyID] = oldKey
+	}
+	if oldKey.Name != job.KeyName {
+		return false, nil
+	}
+	if !model.KeyStatus(oldKey.Status).CanDecrypt() {
+		return false, fmt.Errorf("key version %d is %s", oldKey.Version, oldKey.Status)
+	}
+
+	ciphertext, err := crypto.RewrapEnvelope(envelope, encryptionContext, oldKey, target)
+	if err != nil {
+		return false, err
+	}
+	replaced, err := source.Replace(ctx, record.ID, record.Ciphertext, ciphertext)
+	if err != nil {
+		return false, err
+	}
+	if !replaced {
+		return false, errors.New("record changed while it was being rewrapped")
+	}
+	return true, nil
+}
@@ -0,0 +1,184 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// Record is one stored ciphertext and the primary key of the row that holds it.
+type Record struct {
+	ID         string
+	Ciphertext []byte
+}
+
+// Source is a ciphertext store a rewrap job can walk. Records are returned in a stable
+// order by ID, so the ID of the last record of a batch is a resumable checkpoint.
+type Source interface {
+	// Next returns up to limit records with an ID after the given one, or from the
+	// start when after is empty.
+	Next(ctx context.Context, after string, limit int) ([]Record, error)
+	// Replace stores updated in place of old. It returns false if the row changed in the meantime.
+	Replace(ctx context.Context, id string, old, updated []byte) (bool, error)
+}
+
+// idColumnTypes are the primary key types a Postgres source can be paginated by.
+var idColumnTypes = map[string]bool{
+	"smallint":          true,
+	"integer":           true,
+	"bigint":            true,
+	"uuid":              true,
+	"text":              true,
+	"character varying": true,
+}
+
+// internalTables hold the keystore itself and must never be rewritten by a job.
+var internalTables = map[string]bool{
+	"encryption_keys":  true,
+	"keyrings":         true,
+	"seal_config":      true,
+	"rewrap_jobs":      true,
+	"api_clients":      true,
+	"goose_db_version": true,
+}
+
+// PostgresSource reads envelopes from a table reachable through the service's own database.
+// A bytea column holds raw envelopes, a text column holds them base64 encoded.
+type PostgresSource struct {
+	db               *sql.DB
+	table            string
+	idColumn         string
+	ciphertextColumn string
+	idType           string
+	binary           bool
+}
+
+// NewPostgresSource checks that the table and columns exist and have supported types.
+// table may be schema qualified, otherwise the current schema is used.
+func NewPostgresSource(ctx context.Context, db *sql.DB, table, idColumn, ciphertextColumn string) (*PostgresSource, error) {
+	schema, name := "", table
+	if i := strings.IndexByte(table, '.'); i >= 0 {
+		schema, name = table[:i], table[i+1:]
+	}
+	if internalTables[name] {
+		return nil, fmt.Errorf("table %q belongs to the keystore", table)
+	}
+
+	columnType := func(column string) (string, error) {
+		var dataType string
+		err := db.QueryRowContext(ctx, `
+			SELECT data_type FROM information_schema.columns
+			WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema()) AND table_name = $2 AND column_name = $3`,
+			schema, name, column,
+		).Scan(&dataType)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("column %q does not exist on table %q", column, table)
+		}
+		return dataType, err
+	}
+
+	idType, err := columnType(idColumn)
+	if err != nil {
+		return nil, err
+	}
+	if !idColumnTypes[idType] {
+		return nil, fmt.Errorf("id column %q has unsupported type %s", idColumn, idType)
+	}
+
+	ciphertextType, err := columnType(ciphertextColumn)
+	if err != nil {
+		return nil, err
+	}
+	if ciphertextType != "bytea" && ciphertextType != "text" && ciphertextType != "character varying" {
+		return nil, fmt.Errorf("ciphertext column %q must be bytea or text, not %s", ciphertextColumn, ciphertextType)
+	}
+
+	identifier := pgx.Identifier{name}
+	if schema != "" {
+		identifier = pgx.Identifier{schema, name}
+	}
+	return &PostgresSource{
+		db:               db,
+		table:            identifier.Sanitize(),
+		idColumn:         pgx.Identifier{idColumn}.Sanitize(),
+		ciphertextColumn: pgx.Identifier{ciphertextColumn}.Sanitize(),
+		idType:           idType,
+		binary:           ciphertextType == "bytea",
+	}, nil
+}
+
+func NewSource(ctx context.Context, db *sql.DB, job *model.RewrapJob) (Source, error) {
+	switch job.SourceType {
+	case model.JobSourcePostgres:
+		return NewPostgresSource(ctx, db, job.SourceTable, job.IDColumn, job.CiphertextColumn)
+	default:
+		return nil, fmt.Errorf("unsupported source type %q", job.SourceType)
+	}
+}
+
+func (s *PostgresSource) Next(ctx context.Context, after string, limit int) ([]Record, error) {
+	// idType comes from the allow-list above, never from the caller.
+	query := fmt.Sprintf(`SELECT %[1]s::text, %[2]s FROM %[3]s WHERE %[2]s IS NOT NULL`, s.idColumn, s.ciphertextColumn, s.table)
+	args := []any{limit}
+	if after != "" {
+		query += fmt.Sprintf(` AND %s > CAST($2 AS %s)`, s.idColumn, s.idType)
+		args = append(args, after)
+	}
+	query += fmt.Sprintf(` ORDER BY %s LIMIT $1`, s.idColumn)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var record Record
+		var stored []byte
+		if err := rows.Scan(&record.ID, &stored); err != nil {
+			return nil, err
+		}
+		record.Ciphertext, err = s.decode(stored)
+		if err != nil {
+			// Undecodable rows are still returned so the job can count them as failed.
+			record.Ciphertext = nil
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *PostgresSource) Replace(ctx context.Context, id string, old, updated []byte) (bool, error) {
+	query := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s = CAST($2 AS %s) AND %s = $3`,
+		s.table, s.ciphertextColumn, s.idColumn, s.idType, s.ciphertextColumn)
+	res, err := s.db.ExecContext(ctx, query, s.encode(updated), id, s.encode(old))
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (s *PostgresSource) decode(stored []byte) ([]byte, error) {
+	if s.binary {
+		return stored, nil
+	}
+	return base64.StdEncoding.DecodeString(string(stored))
+}
+
+func (s *PostgresSource) encode(ciphertext []byte) any {
+	if s.binary {
+		return ciphertext
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext)
+}
@@ -0,0 +1,261 @@
+package keycache
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/seal"
+	"github.com/valu/encrpytion/pkg/crypto"
+)
+
+const (
+	DefaultTTL        = 5 * time.Minute
+	DefaultMaxEntries = 1000
+)
+
+// Cache keeps unwrapped master key versions in memory so that encrypt and decrypt do not
+// hit the database and unwrap the key material on every request. Entries expire after
+// the TTL and the least recently used ones are evicted beyond MaxEntries. Keys are
+// invalidated by name whenever they are created, rotated or change status, see Listen.
+type Cache struct {
+	db         repository.KeyStore
+	barrier    *seal.Barrier
+	log        *zerolog.Logger
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[uuid.UUID]*list.Element
+	primary map[string]primaryEntry
+	// generation is bumped on every invalidation. A lookup that raced with an
+	// invalidation does not store its result, it may already be stale.
+	generation uint64
+}
+
+type entry struct {
+	key     *crypto.MasterKey
+	expires time.Time
+}
+
+type primaryEntry struct {
+	keyID   uuid.UUID
+	expires time.Time
+}
+
+func New(db repository.KeyStore, barrier *seal.Barrier, ttl time.Duration, maxEntries int, log *zerolog.Logger) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	return &Cache{
+		db:         db,
+		barrier:    barrier,
+		log:        log,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[uuid.UUID]*list.Element),
+		primary:    make(map[string]primaryEntry),
+	}
+}
+
+// Primary returns the current ACTIVE version of the named key. It returns sql.ErrNoRows
+// when the key does not exist or has no ACTIVE version.
+func (c *Cache) Primary(ctx context.Context, name string) (*crypto.MasterKey, error) {
+	if err := c.checkSealed(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	if p, ok := c.primary[name]; ok && now.Before(p.expires) {
+		if key := c.lookup(p.keyID, now); key != nil {
+			c.mu.Unlock()
+			return key, nil
+		}
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	key, err := c.db.GetCurrentActiveKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	masterKey, err := c.unwrap(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.store(masterKey, now)
+		c.primary[name] = primaryEntry{keyID: key.KeyID, expires: now.Add(c.ttl)}
+	}
+	return masterKey, nil
+}
+
+// Get returns the master key version with the given key id in any status. It returns
+// sql.ErrNoRows when the version does not exist.
+func (c *Cache) Get(ctx context.Context, keyID uuid.UUID) (*crypto.MasterKey, error) {
+	if err := c.checkSealed(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	if key := c.lookup(keyID, now); key != nil {
+		c.mu.Unlock()
+		return key, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	key, err := c.db.GetKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	masterKey, err := c.unwrap(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.store(masterKey, now)
+	}
+	return masterKey, nil
+}
+
+// Version returns the given version of the named key in any status. It returns
+// sql.ErrNoRows when the key or the version does not exist.
+func (c *Cache) Version(ctx context.Context, name string, version int) (*crypto.MasterKey, error) {
+	if err := c.checkSealed(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	// Entries are indexed by key id, scanning them is cheap within the size bound.
+	for keyID, element := range c.entries {
+		key := element.Value.(*entry).key
+		if key.Name == name && key.Version == version {
+			if key := c.lookup(keyID, now); key != nil {
+				c.mu.Unlock()
+				return key, nil
+			}
+			break
+		}
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	versions, err := c.db.GetAllKeyVersions(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := versions[uint32(version)]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	masterKey, err := c.unwrap(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.store(masterKey, now)
+	}
+	return masterKey, nil
+}
+
+// Invalidate drops every cached version of the named key.
+func (c *Cache) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	delete(c.primary, name)
+	for keyID, element := range c.entries {
+		if element.Value.(*entry).key.Name == name {
+			c.lru.Remove(element)
+			delete(c.entries, keyID)
+		}
+	}
+}
+
+// Purge drops every cached key.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.lru.Init()
+	clear(c.entries)
+	clear(c.primary)
+}
+
+// checkSealed purges the cache once the barrier is sealed, unwrapped keys must not
+// outlive the root key.
+func (c *Cache) checkSealed() error {
+	if !c.barrier.Sealed() {
+		return nil
+	}
+	c.Purge()
+	return seal.ErrSealed
+}
+
+func (c *Cache) unwrap(key *model.EncryptionKey) (*crypto.MasterKey, error) {
+	rootKey, err := c.barrier.RootKey()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.UnwrapMasterKey(key, rootKey)
+}
+
+// lookup returns a live entry and marks it as recently used. The caller holds mu.
+func (c *Cache) lookup(keyID uuid.UUID, now time.Time) *crypto.MasterKey {
+	element, ok := c.entries[keyID]
+	if !ok {
+		return nil
+	}
+	e := element.Value.(*entry)
+	if !now.Before(e.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, keyID)
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return e.key
+}
+
+// store adds an entry and evicts the least recently used ones beyond the size bound.
+// The caller holds mu.
+func (c *Cache) store(key *crypto.MasterKey, now time.Time) {
+	e := &entry{key: key, expires: now.Add(c.ttl)}
+	if element, ok := c.entries[key.KeyID]; ok {
+		element.Value = e
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key.KeyID] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).key.KeyID)
+	}
+}
@@ -0,0 +1,56 @@
+package keycache
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/valu/encrpytion/internal/repository"
+)
+
+const reconnectDelay = 5 * time.Second
+
+// Listen subscribes to key change notifications on a dedicated connection and drops the
+// keys they name, so that rotations and status changes made through any replica take
+// effect everywhere. While the connection is down notifications are lost, so the whole
+// cache is purged before listening again. Listen blocks until ctx is cancelled.
+func (c *Cache) Listen(ctx context.Context, dbUrl string) {
+	for {
+		err := c.listen(ctx, dbUrl)
+		if ctx.Err() != nil {
+			return
+		}
+		c.log.Error().Err(err).Msg("Key change listener disconnected, retrying")
+		c.Purge()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (c *Cache) listen(ctx context.Context, dbUrl string) error {
+	conn, err := pgx.Connect(ctx, dbUrl)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{repository.KeyChangesChannel}.Sanitize()); err != nil {
+		return err
+	}
+	// Keys cached before the subscription may have changed in the meantime.
+	c.Purge()
+	c.log.Info().Str("channel", repository.KeyChangesChannel).Msg("Listening for key changes")
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		c.log.Debug().Str("key_name", notification.Payload).Msg("Key changed, invalidating cache")
+		c.Invalidate(notification.Payload)
+	}
+}
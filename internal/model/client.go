@@ -0,0 +1,93 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scope is a permission granted to an API client.
+type Scope string
+
+const (
+	// ScopeKeysAdmin manages keys and their versions, rewrap jobs and the seal.
+	ScopeKeysAdmin Scope = "keys:admin"
+	// ScopeCryptoEncrypt produces ciphertexts and data keys.
+	ScopeCryptoEncrypt Scope = "crypto:encrypt"
+	// ScopeCryptoDecrypt opens ciphertexts and data keys.
+	ScopeCryptoDecrypt Scope = "crypto:decrypt"
+	// ScopeCryptoSign signs with sign keys.
+	ScopeCryptoSign Scope = "crypto:sign"
+	// ScopeCryptoVerify checks signatures. It grants nothing else, verifiers need not be
+	// able to decrypt.
+	ScopeCryptoVerify Scope = "crypto:verify"
+	// ScopeCryptoMAC generates and checks MACs, both need the secret key.
+	ScopeCryptoMAC Scope = "crypto:mac"
+	// ScopeClientsAdmin issues and revokes API client tokens.
+	ScopeClientsAdmin Scope = "clients:admin"
+)
+
+// AllScopes are granted to the root client created by /v1/sys/init.
+var AllScopes = []Scope{
+	ScopeKeysAdmin, ScopeCryptoEncrypt, ScopeCryptoDecrypt, ScopeCryptoSign, ScopeCryptoVerify, ScopeCryptoMAC,
+	ScopeClientsAdmin,
+}
+
+// APIClient is a caller of the API that authenticates with a bearer token or, when
+// CertificateIdentity is set, with a TLS client certificate. Only an argon2id hash of
+// the token's secret is stored, the token is shown once on issue.
+type APIClient struct {
+	ID         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	Scopes     []Scope   `json:"scopes"`
+	SecretHash string    `json:"-"`
+	// CertificateIdentity is the SPIFFE ID or subject of the client certificates the
+	// client authenticates with. Such clients have no token.
+	CertificateIdentity string    `json:"certificate_identity,omitempty"`
+	CreationDate        time.Time `json:"creation_date"`
+	// RevocationDate is set once the client is revoked, its token is refused from then on.
+	RevocationDate *time.Time `json:"revocation_date,omitempty"`
+}
+
+func (c *APIClient) Revoked() bool {
+	return c.RevocationDate != nil
+}
+
+func (c *APIClient) HasScope(scope Scope) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// ValidateClient checks the name and scopes of a client to be issued.
+func ValidateClient(name string, scopes []Scope) error {
+	if !keyNamePattern.MatchString(name) {
+		return errors.New("client name must be 1-64 characters of letters, digits, '-' or '_'")
+	}
+	if len(scopes) == 0 {
+		return errors.New("at least one scope must be granted")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(AllScopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// ValidateCertificateIdentity checks the identity a certificate client is bound to, a
+// SPIFFE ID such as spiffe://example.org/billing or a subject such as CN=billing,O=Acme.
+func ValidateCertificateIdentity(identity string) error {
+	if identity == "" || len(identity) > 2048 {
+		return errors.New("certificate identity must be 1-2048 characters")
+	}
+	if rest, ok := strings.CutPrefix(identity, "spiffe://"); ok {
+		if u, err := url.Parse(identity); err != nil || rest == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("certificate identity %q is not a valid SPIFFE ID", identity)
+		}
+	}
+	return nil
+}
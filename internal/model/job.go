@@ -0,0 +1,42 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusPending   JobStatus = "PENDING"
+	JobStatusRunning   JobStatus = "RUNNING"
+	JobStatusCompleted JobStatus = "COMPLETED"
+	JobStatusFailed    JobStatus = "FAILED"
+	JobStatusCancelled JobStatus = "CANCELLED"
+)
+
+// JobSourcePostgres is a table/column in the service's own Postgres database.
+const JobSourcePostgres = "postgres"
+
+// RewrapJob walks a registered ciphertext store in batches and rewraps every envelope
+// of KeyName older than BelowVersion under the key's primary version.
+type RewrapJob struct {
+	ID                uuid.UUID         `json:"id"`
+	KeyName           string            `json:"key_name"`
+	SourceType        string            `json:"source_type"`
+	SourceTable       string            `json:"source_table"`
+	IDColumn          string            `json:"id_column"`
+	CiphertextColumn  string            `json:"ciphertext_column"`
+	EncryptionContext map[string]string `json:"encryption_context,omitempty"`
+	BelowVersion      int               `json:"below_version"`
+	BatchSize         int               `json:"batch_size"`
+	Status            string            `json:"status"`
+	Checkpoint        string            `json:"checkpoint,omitempty"`
+	Processed         int64             `json:"processed"`
+	Rewrapped         int64             `json:"rewrapped"`
+	Failed            int64             `json:"failed"`
+	Error             string            `json:"error,omitempty"`
+	CreationDate      time.Time         `json:"creation_date"`
+	UpdatedDate       time.Time         `json:"updated_date"`
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,17 +11,85 @@ import (
 type EncryptionKey struct {
 	ID                   int64     `json:"id"`
 	KeyID                uuid.UUID `json:"key_id"`
-	EncryptedKeyMaterial []byte    `json:"encrypted_key_material"`
+	Name                 string    `json:"name"`
+	EncryptedKeyMaterial []byte    `json:"-"`
 	CreationDate         time.Time `json:"creation_date"`
 	ExpirationDate       time.Time `json:"expiration_date"`
-	Status               string    `json:"status"`
-	Version              int       `json:"version"`
+	// DeletionDate is set while the version is scheduled for deletion, it can be
+	// destroyed from then on.
+	DeletionDate *time.Time `json:"deletion_date,omitempty"`
+	Status       string     `json:"status"`
+	Version      int        `json:"version"`
+	// Algorithm names the cipher suite of the key, it is chosen when the key is
+	// created and carried over to every rotated version.
+	Algorithm string `json:"algorithm"`
+	// PublicKey is the PKIX encoded public half of an asymmetric version, whose private
+	// half is wrapped in EncryptedKeyMaterial. It is nil for symmetric versions.
+	PublicKey []byte `json:"-"`
+}
+
+// Expired reports whether the version is past its expiration date. Expired versions
+// can still decrypt but must not encrypt new data.
+func (k *EncryptionKey) Expired(now time.Time) bool {
+	return !k.ExpirationDate.IsZero() && now.After(k.ExpirationDate)
 }
 
 type KeyStatus string
 
 const (
-	KeyStatusActive   KeyStatus = "ACTIVE"
-	KeyStatusInactive KeyStatus = "INACTIVE"
-	KeyStatusRotated  KeyStatus = "ROTATED"
+	// KeyStatusPending versions exist but their material is not usable yet.
+	KeyStatusPending KeyStatus = "PENDING"
+	// KeyStatusActive is the primary version, it encrypts and decrypts.
+	KeyStatusActive KeyStatus = "ACTIVE"
+	// KeyStatusRotated versions were replaced by a newer primary, they only decrypt.
+	KeyStatusRotated KeyStatus = "ROTATED"
+	// KeyStatusDisabled versions can neither encrypt nor decrypt until they are enabled again.
+	KeyStatusDisabled KeyStatus = "DISABLED"
+	// KeyStatusScheduledForDeletion versions are unusable and get destroyed once their deletion date passes.
+	KeyStatusScheduledForDeletion KeyStatus = "SCHEDULED_FOR_DELETION"
+	// KeyStatusDestroyed versions have had their material erased, only the metadata is kept.
+	KeyStatusDestroyed KeyStatus = "DESTROYED"
 )
+
+var ErrInvalidTransition = errors.New("invalid key status transition")
+
+// ErrPrimaryVersion is returned for disabling or scheduling the deletion of the ACTIVE
+// version, which would leave the key unable to encrypt. It wraps ErrInvalidTransition.
+var ErrPrimaryVersion = fmt.Errorf("%w, the primary version cannot be disabled or scheduled for deletion, rotate the key first", ErrInvalidTransition)
+
+// keyTransitions lists every status a version may move to from its current status. The
+// ACTIVE version only leaves that status when a rotation replaces it, so a disabled
+// version always has a newer one and comes back as ROTATED.
+var keyTransitions = map[KeyStatus][]KeyStatus{
+	KeyStatusPending:              {KeyStatusActive, KeyStatusDisabled},
+	KeyStatusActive:               {KeyStatusRotated},
+	KeyStatusRotated:              {KeyStatusDisabled, KeyStatusScheduledForDeletion},
+	KeyStatusDisabled:             {KeyStatusRotated, KeyStatusScheduledForDeletion},
+	KeyStatusScheduledForDeletion: {KeyStatusDisabled, KeyStatusDestroyed},
+	KeyStatusDestroyed:            {},
+}
+
+// ValidateTransition returns ErrInvalidTransition unless a version may move from one status to the other.
+func ValidateTransition(from, to KeyStatus) error {
+	if from == KeyStatusActive && (to == KeyStatusDisabled || to == KeyStatusScheduledForDeletion) {
+		return ErrPrimaryVersion
+	}
+	for _, allowed := range keyTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, from, to)
+}
+
+// CanEncrypt reports whether a version in this status may encrypt new data, or
+// compute new MACs.
+func (s KeyStatus) CanEncrypt() bool {
+	return s == KeyStatusActive
+}
+
+// CanDecrypt reports whether a version in this status may decrypt existing data, or
+// verify existing MACs.
+func (s KeyStatus) CanDecrypt() bool {
+	return s == KeyStatusActive || s == KeyStatusRotated
+}
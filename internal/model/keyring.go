@@ -0,0 +1,72 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// DefaultKeyName is the keyring used when a request does not name one. Keys created
+// before keyrings existed were migrated into it.
+const DefaultKeyName = "default"
+
+// MaxRotationPeriodDays keeps periodic rotation within the lifetime of a key version.
+const MaxRotationPeriodDays = 365
+
+// KeyPurpose is what the versions of a key may be used for. It is chosen when the key
+// is created and decides which algorithms the key can have.
+type KeyPurpose string
+
+const (
+	// KeyPurposeEncrypt keys encrypt with random nonces under envelope encryption.
+	KeyPurposeEncrypt KeyPurpose = "encrypt"
+	// KeyPurposeDeterministic keys encrypt equal plaintexts to equal ciphertexts, so
+	// encrypted fields can be looked up by equality.
+	KeyPurposeDeterministic KeyPurpose = "deterministic"
+	// KeyPurposeMAC keys compute and verify keyed hashes.
+	KeyPurposeMAC KeyPurpose = "mac"
+	// KeyPurposeSign keys are asymmetric, they sign with a private half that never
+	// leaves the service and verify with a public half that can be exported.
+	KeyPurposeSign KeyPurpose = "sign"
+	// KeyPurposeAsymmetricDecrypt keys are asymmetric, partners encrypt offline to the
+	// exported public half and only the service can decrypt.
+	KeyPurposeAsymmetricDecrypt KeyPurpose = "asymmetric_decrypt"
+)
+
+type Keyring struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Purpose      KeyPurpose `json:"purpose"`
+	CreationDate time.Time  `json:"creation_date"`
+	// RotationPeriodDays is how old the primary version may get before the scheduler
+	// rotates it. 0 only rotates shortly before the primary version expires.
+	RotationPeriodDays int              `json:"rotation_period_days"`
+	PrimaryVersion     int              `json:"primary_version"`
+	Versions           []*EncryptionKey `json:"versions,omitempty"`
+}
+
+var keyNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// reservedKeyNames collide with static routes under /v1/keys.
+var reservedKeyNames = map[string]bool{
+	"active": true,
+	"rotate": true,
+}
+
+func ValidateKeyName(name string) error {
+	if !keyNamePattern.MatchString(name) {
+		return errors.New("key name must be 1-64 characters of letters, digits, '-' or '_'")
+	}
+	if reservedKeyNames[name] {
+		return errors.New("key name is reserved")
+	}
+	return nil
+}
+
+func ValidateRotationPeriod(days int) error {
+	if days < 0 || days > MaxRotationPeriodDays {
+		return fmt.Errorf("rotation_period_days must be between 0 and %d", MaxRotationPeriodDays)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+type SealConfig struct {
+	SecretShares    int       `json:"secret_shares"`
+	SecretThreshold int       `json:"secret_threshold"`
+	RootKeyCheck    []byte    `json:"-"`
+	CreationDate    time.Time `json:"creation_date"`
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// ErrIdentityTaken is returned when a certificate identity is already bound to a client
+// that is not revoked.
+var ErrIdentityTaken = errors.New("certificate identity is already bound to an API client")
+
+const clientColumns = `id, name, scopes, secret_hash, certificate_identity, creation_date, revocation_date`
+
+// EncodeScopes stores scopes as one space separated column, like OAuth scope strings.
+func EncodeScopes(scopes []model.Scope) string {
+	encoded := make([]string, len(scopes))
+	for i, scope := range scopes {
+		encoded[i] = string(scope)
+	}
+	return strings.Join(encoded, " ")
+}
+
+func DecodeScopes(encoded string) []model.Scope {
+	var scopes []model.Scope
+	for _, scope := range strings.Fields(encoded) {
+		scopes = append(scopes, model.Scope(scope))
+	}
+	return scopes
+}
+
+func scanClient(row scanner) (*model.APIClient, error) {
+	var client model.APIClient
+	var scopes string
+	var identity sql.NullString
+	err := row.Scan(&client.ID, &client.Name, &scopes, &client.SecretHash, &identity, &client.CreationDate, &client.RevocationDate)
+	if err != nil {
+		return nil, err
+	}
+	client.Scopes = DecodeScopes(scopes)
+	client.CertificateIdentity = identity.String
+	return &client, nil
+}
+
+// CreateAPIClient returns ErrIdentityTaken when the certificate identity of the client is
+// bound to another client that is not revoked.
+func (db *DB) CreateAPIClient(ctx context.Context, client *model.APIClient) error {
+	query := `
+		INSERT INTO api_clients (id, name, scopes, secret_hash, certificate_identity, creation_date)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := db.ExecContext(ctx, query,
+		client.ID, client.Name, EncodeScopes(client.Scopes), client.SecretHash,
+		NullString(client.CertificateIdentity), client.CreationDate,
+	)
+	if client.CertificateIdentity != "" && isUniqueViolation(err) {
+		return ErrIdentityTaken
+	}
+	return err
+}
+
+func (db *DB) GetAPIClient(ctx context.Context, id uuid.UUID) (*model.APIClient, error) {
+	query := `SELECT ` + clientColumns + ` FROM api_clients WHERE id = $1`
+	return scanClient(db.QueryRowContext(ctx, query, id))
+}
+
+// GetAPIClientByIdentity returns the client bound to a certificate identity that is not
+// revoked, a unique index allows one at most. It returns sql.ErrNoRows when there is none.
+func (db *DB) GetAPIClientByIdentity(ctx context.Context, identity string) (*model.APIClient, error) {
+	query := `
+		SELECT ` + clientColumns + ` FROM api_clients
+		WHERE certificate_identity = $1 AND revocation_date IS NULL`
+	return scanClient(db.QueryRowContext(ctx, query, identity))
+}
+
+func (db *DB) ListAPIClients(ctx context.Context) ([]*model.APIClient, error) {
+	query := `SELECT ` + clientColumns + ` FROM api_clients ORDER BY creation_date, name`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return scanClients(rows)
+}
+
+// RevokeAPIClient sets the revocation date of a client that is not revoked yet and
+// returns the client. It returns sql.ErrNoRows when the client does not exist.
+func (db *DB) RevokeAPIClient(ctx context.Context, id uuid.UUID, now time.Time) (*model.APIClient, error) {
+	query := `
+		UPDATE api_clients
+		SET revocation_date = COALESCE(revocation_date, $2)
+		WHERE id = $1
+		RETURNING ` + clientColumns
+	return scanClient(db.QueryRowContext(ctx, query, id, now))
+}
+
+// NullString stores an empty string as NULL.
+func NullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func scanClients(rows *sql.Rows) ([]*model.APIClient, error) {
+	defer rows.Close()
+	var clients []*model.APIClient
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
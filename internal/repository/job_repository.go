@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/model"
+)
+
+const jobColumns = `id, key_name, source_type, source_table, id_column, ciphertext_column, encryption_context,
+	below_version, batch_size, status, checkpoint, processed, rewrapped, failed, error, creation_date, updated_date`
+
+func scanJob(row scanner) (*model.RewrapJob, error) {
+	var job model.RewrapJob
+	var encryptionContext []byte
+	var checkpoint, jobErr sql.NullString
+	err := row.Scan(
+		&job.ID, &job.KeyName, &job.SourceType, &job.SourceTable, &job.IDColumn, &job.CiphertextColumn, &encryptionContext,
+		&job.BelowVersion, &job.BatchSize, &job.Status, &checkpoint, &job.Processed, &job.Rewrapped, &job.Failed, &jobErr,
+		&job.CreationDate, &job.UpdatedDate,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(encryptionContext, &job.EncryptionContext); err != nil {
+		return nil, err
+	}
+	job.Checkpoint = checkpoint.String
+	job.Error = jobErr.String
+	return &job, nil
+}
+
+func (db *DB) CreateJob(ctx context.Context, job *model.RewrapJob) error {
+	encryptionContext, err := json.Marshal(job.EncryptionContext)
+	if err != nil {
+		return err
+	}
+	if job.EncryptionContext == nil {
+		encryptionContext = []byte("{}")
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO rewrap_jobs (id, key_name, source_type, source_table, id_column, ciphertext_column, encryption_context,
+			below_version, batch_size, status, creation_date, updated_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		job.ID, job.KeyName, job.SourceType, job.SourceTable, job.IDColumn, job.CiphertextColumn, encryptionContext,
+		job.BelowVersion, job.BatchSize, job.Status, job.CreationDate, job.UpdatedDate,
+	)
+	return err
+}
+
+func (db *DB) GetJob(ctx context.Context, id uuid.UUID) (*model.RewrapJob, error) {
+	query := `SELECT ` + jobColumns + ` FROM rewrap_jobs WHERE id = $1`
+	return scanJob(db.QueryRowContext(ctx, query, id))
+}
+
+func (db *DB) ListJobs(ctx context.Context) ([]*model.RewrapJob, error) {
+	query := `SELECT ` + jobColumns + ` FROM rewrap_jobs ORDER BY creation_date DESC`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []*model.RewrapJob
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// ClaimJob hands the oldest pending job, or a running job whose owner stopped renewing
+// its lease, to owner. It returns sql.ErrNoRows when there is nothing to do.
+func (db *DB) ClaimJob(ctx context.Context, owner uuid.UUID, lease time.Duration) (*model.RewrapJob, error) {
+	query := `
+		UPDATE rewrap_jobs
+		SET status = 'RUNNING', owner = $1, lease_expires_at = $2, updated_date = $3
+		WHERE id = (
+			SELECT id FROM rewrap_jobs
+			WHERE status = 'PENDING' OR (status = 'RUNNING' AND lease_expires_at < $3)
+			ORDER BY creation_date
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns
+	now := time.Now()
+	return scanJob(db.QueryRowContext(ctx, query, owner, now.Add(lease), now))
+}
+
+// CheckpointJob stores the progress of a running job and renews its lease. It returns
+// false when the job was cancelled or taken over, in which case the owner must stop.
+func (db *DB) CheckpointJob(ctx context.Context, job *model.RewrapJob, owner uuid.UUID, lease time.Duration) (bool, error) {
+	now := time.Now()
+	res, err := db.ExecContext(ctx, `
+		UPDATE rewrap_jobs
+		SET checkpoint = $1, processed = $2, rewrapped = $3, failed = $4, lease_expires_at = $5, updated_date = $6
+		WHERE id = $7 AND owner = $8 AND status = 'RUNNING'`,
+		job.Checkpoint, job.Processed, job.Rewrapped, job.Failed, now.Add(lease), now, job.ID, owner,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// FinishJob moves a running job owned by owner to a final status.
+func (db *DB) FinishJob(ctx context.Context, job *model.RewrapJob, owner uuid.UUID, status model.JobStatus, jobErr string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE rewrap_jobs
+		SET status = $1, error = NULLIF($2, ''), checkpoint = $3, processed = $4, rewrapped = $5, failed = $6,
+			lease_expires_at = NULL, updated_date = $7
+		WHERE id = $8 AND owner = $9 AND status = 'RUNNING'`,
+		string(status), jobErr, job.Checkpoint, job.Processed, job.Rewrapped, job.Failed, time.Now(), job.ID, owner,
+	)
+	return err
+}
+
+// CancelJob cancels a pending or running job. It returns false if the job had already finished.
+func (db *DB) CancelJob(ctx context.Context, id uuid.UUID) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE rewrap_jobs
+		SET status = 'CANCELLED', lease_expires_at = NULL, updated_date = $1
+		WHERE id = $2 AND status IN ('PENDING', 'RUNNING')`,
+		time.Now(), id,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
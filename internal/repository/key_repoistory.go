@@ -3,11 +3,25 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/valu/encrpytion/internal/model"
 )
 
+var ErrKeyringExists = errors.New("a key with this name already exists")
+
+// VersionConflictError is returned when a rotation lost a race against another rotation.
+type VersionConflictError struct {
+	CurrentVersion int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("key was rotated concurrently, the primary version is now %d", e.CurrentVersion)
+}
+
 type DB struct {
 	*sql.DB
 }
@@ -16,35 +30,76 @@ func New(db *sql.DB) *DB {
 	return &DB{DB: db}
 }
 
+const keyColumns = `id, key_id, key_name, encrypted_key_material, creation_date, expiration_date, deletion_date, status, version, algorithm, public_key`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanKey(row scanner) (*model.EncryptionKey, error) {
+	var key model.EncryptionKey
+	err := row.Scan(
+		&key.ID, &key.KeyID, &key.Name, &key.EncryptedKeyMaterial, &key.CreationDate, &key.ExpirationDate, &key.DeletionDate,
+		&key.Status, &key.Version, &key.Algorithm, &key.PublicKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func scanKeys(rows *sql.Rows) ([]*model.EncryptionKey, error) {
+	defer rows.Close()
+	var keys []*model.EncryptionKey
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// KeyChangesChannel is the Postgres NOTIFY channel that carries the name of a key whenever
+// one of its versions is created or changes status, so that caches in every replica can
+// drop it.
+const KeyChangesChannel = "key_changes"
+
+// notifyKeyChange queues a notification for the named key. Postgres only delivers it when
+// the transaction commits.
+func notifyKeyChange(ctx context.Context, tx *sql.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, KeyChangesChannel, name)
+	return err
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
 func (db *DB) CreateKey(ctx context.Context, key *model.EncryptionKey) error {
 	query := `
-		INSERT INTO encryption_keys (key_id, encrypted_key_material, creation_date, expiration_date, status, version)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO encryption_keys (key_id, key_name, encrypted_key_material, creation_date, expiration_date, status, version, algorithm, public_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id`
 	err := db.QueryRowContext(ctx, query,
-		key.KeyID, key.EncryptedKeyMaterial, key.CreationDate, key.ExpirationDate, key.Status, key.Version,
+		key.KeyID, key.Name, key.EncryptedKeyMaterial, key.CreationDate, key.ExpirationDate, key.Status, key.Version, key.Algorithm, key.PublicKey,
 	).Scan(&key.ID)
 	return err
 }
 
 func (db *DB) GetKey(ctx context.Context, keyID uuid.UUID) (*model.EncryptionKey, error) {
 	query := `
-		SELECT id, key_id, encrypted_key_material, creation_date, expiration_date, status, version
+		SELECT ` + keyColumns + `
 		FROM encryption_keys
 		WHERE key_id = $1`
-	var key model.EncryptionKey
-	err := db.QueryRowContext(ctx, query, keyID).Scan(
-		&key.ID, &key.KeyID, &key.EncryptedKeyMaterial, &key.CreationDate, &key.ExpirationDate, &key.Status, &key.Version,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return &key, nil
+	return scanKey(db.QueryRowContext(ctx, query, keyID))
 }
 
 func (db *DB) ListActiveKeys(ctx context.Context) ([]*model.EncryptionKey, error) {
 	query := `
-		SELECT id, key_id, encrypted_key_material, creation_date, expiration_date, status, version
+		SELECT ` + keyColumns + `
 		FROM encryption_keys
 		WHERE status = 'ACTIVE'
 		ORDER BY creation_date DESC`
@@ -52,85 +107,137 @@ func (db *DB) ListActiveKeys(ctx context.Context) ([]*model.EncryptionKey, error
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var keys []*model.EncryptionKey
-	for rows.Next() {
-		var key model.EncryptionKey
-		err := rows.Scan(
-			&key.ID, &key.KeyID, &key.EncryptedKeyMaterial, &key.CreationDate, &key.ExpirationDate, &key.Status, &key.Version,
-		)
-		if err != nil {
-			return nil, err
-		}
-		keys = append(keys, &key)
-	}
-	return keys, nil
+	return scanKeys(rows)
 }
 
-func (db *DB) GetAllKeyVersions(ctx context.Context) (map[uint32]*model.EncryptionKey, error) {
+// GetAllKeyVersions returns every version of the named key, indexed by version.
+func (db *DB) GetAllKeyVersions(ctx context.Context, name string) (map[uint32]*model.EncryptionKey, error) {
 	query := `
-		SELECT id, key_id, encrypted_key_material, creation_date, expiration_date, status, version
+		SELECT ` + keyColumns + `
 		FROM encryption_keys
+		WHERE key_name = $1
 		ORDER BY version`
-	rows, err := db.QueryContext(ctx, query)
+	rows, err := db.QueryContext(ctx, query, name)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := scanKeys(rows)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	keyVersions := make(map[uint32]*model.EncryptionKey)
-	for rows.Next() {
-		var key model.EncryptionKey
-		err := rows.Scan(
-			&key.ID, &key.KeyID, &key.EncryptedKeyMaterial, &key.CreationDate, &key.ExpirationDate, &key.Status, &key.Version,
-		)
-		if err != nil {
-			return nil, err
-		}
-		keyVersions[uint32(key.Version)] = &key
+	keyVersions := make(map[uint32]*model.EncryptionKey, len(keys))
+	for _, key := range keys {
+		keyVersions[uint32(key.Version)] = key
 	}
 	return keyVersions, nil
 }
 
-func (db *DB) GetCurrentActiveKey(ctx context.Context) (*model.EncryptionKey, error) {
+// GetCurrentActiveKey returns the primary version of the named key, the highest ACTIVE version.
+func (db *DB) GetCurrentActiveKey(ctx context.Context, name string) (*model.EncryptionKey, error) {
 	query := `
-		SELECT id, key_id, encrypted_key_material, creation_date, expiration_date, status, version
+		SELECT ` + keyColumns + `
 		FROM encryption_keys
-		WHERE status = 'ACTIVE'
+		WHERE key_name = $1 AND status = 'ACTIVE'
 		ORDER BY version DESC
 		LIMIT 1`
-	var key model.EncryptionKey
-	err := db.QueryRowContext(ctx, query).Scan(
-		&key.ID, &key.KeyID, &key.EncryptedKeyMaterial, &key.CreationDate, &key.ExpirationDate, &key.Status, &key.Version,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return &key, nil
+	return scanKey(db.QueryRowContext(ctx, query, name))
 }
 
-func (db *DB) RotateKey(ctx context.Context, oldKeyID uuid.UUID, newKey *model.EncryptionKey) error {
+// RotateKey makes newKey the primary version of the named key. It is a compare-and-swap:
+// the rotation only happens if expectedVersion is still the primary version, otherwise a
+// *VersionConflictError carries the version that won. Rotations of the same key are
+// serialized on the keyring row, and newKey gets the next free version number.
+func (db *DB) RotateKey(ctx context.Context, name string, expectedVersion int, newKey *model.EncryptionKey) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	var keyringID int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM keyrings WHERE name = $1 FOR UPDATE`, name).Scan(&keyringID)
+	if err != nil {
+		return err
+	}
+
+	var currentVersion, maxVersion int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(version) FILTER (WHERE status = 'ACTIVE'), 0), COALESCE(MAX(version), 0)
+		FROM encryption_keys
+		WHERE key_name = $1`, name,
+	).Scan(&currentVersion, &maxVersion)
+	if err != nil {
+		return err
+	}
+	if currentVersion != expectedVersion {
+		return &VersionConflictError{CurrentVersion: currentVersion}
+	}
+
 	// Update old key status
 	_, err = tx.ExecContext(ctx,
-		`UPDATE encryption_keys SET status = $1 WHERE key_id = $2`,
-		string(model.KeyStatusRotated), oldKeyID)
+		`UPDATE encryption_keys SET status = $1 WHERE key_name = $2 AND status = $3`,
+		string(model.KeyStatusRotated), name, string(model.KeyStatusActive))
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO encryption_keys (key_id, encrypted_key_material, creation_date, expiration_date, status, version)
-		 VALUES ($1, $2, $3, $4, $5, $6)`,
-		newKey.KeyID, newKey.EncryptedKeyMaterial, newKey.CreationDate, newKey.ExpirationDate, newKey.Status, newKey.Version)
+	newKey.Name = name
+	newKey.Version = maxVersion + 1
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO encryption_keys (key_id, key_name, encrypted_key_material, creation_date, expiration_date, status, version, algorithm, public_key)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 RETURNING id`,
+		newKey.KeyID, newKey.Name, newKey.EncryptedKeyMaterial, newKey.CreationDate, newKey.ExpirationDate, newKey.Status, newKey.Version, newKey.Algorithm, newKey.PublicKey,
+	).Scan(&newKey.ID)
+	if isUniqueViolation(err) {
+		// The keyring lock makes this unreachable, the unique indexes are the last line of defence.
+		return &VersionConflictError{CurrentVersion: currentVersion}
+	}
 	if err != nil {
 		return err
 	}
+	if err := notifyKeyChange(ctx, tx, name); err != nil {
+		return err
+	}
 
 	return tx.Commit()
 }
+
+// RewrapLegacyKeys wraps every key whose material is still stored in plaintext.
+// The wrap function receives the key with its raw material and returns the wrapped material.
+func (db *DB) RewrapLegacyKeys(ctx context.Context, wrap func(key *model.EncryptionKey) ([]byte, error)) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+keyColumns+`
+		FROM encryption_keys
+		WHERE material_wrapped = FALSE
+		FOR UPDATE`)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := scanKeys(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		wrapped, err := wrap(key)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE encryption_keys SET encrypted_key_material = $1, material_wrapped = TRUE WHERE id = $2`,
+			wrapped, key.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(keys), tx.Commit()
+}
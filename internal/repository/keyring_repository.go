@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// CreateKeyring creates a named key together with its first version.
+// It returns ErrKeyringExists when the name is taken.
+func (db *DB) CreateKeyring(ctx context.Context, keyring *model.Keyring, firstKey *model.EncryptionKey) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO keyrings (name, purpose, creation_date, rotation_period_days) VALUES ($1, $2, $3, $4) RETURNING id`,
+		keyring.Name, keyring.Purpose, keyring.CreationDate, keyring.RotationPeriodDays,
+	).Scan(&keyring.ID)
+	if isUniqueViolation(err) {
+		return ErrKeyringExists
+	}
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO encryption_keys (key_id, key_name, encrypted_key_material, creation_date, expiration_date, status, version, algorithm, public_key)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 RETURNING id`,
+		firstKey.KeyID, firstKey.Name, firstKey.EncryptedKeyMaterial, firstKey.CreationDate, firstKey.ExpirationDate, firstKey.Status, firstKey.Version, firstKey.Algorithm, firstKey.PublicKey,
+	).Scan(&firstKey.ID)
+	if err != nil {
+		return err
+	}
+	if err := notifyKeyChange(ctx, tx, keyring.Name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetKeyring returns the named key with all of its versions, newest first.
+func (db *DB) GetKeyring(ctx context.Context, name string) (*model.Keyring, error) {
+	keyring, err := scanKeyring(db.QueryRowContext(ctx,
+		`SELECT `+keyringColumns+` FROM keyrings WHERE name = $1`, name,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+keyColumns+`
+		FROM encryption_keys
+		WHERE key_name = $1
+		ORDER BY version DESC`, name)
+	if err != nil {
+		return nil, err
+	}
+	keyring.Versions, err = scanKeys(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keyring.Versions {
+		if key.Status == string(model.KeyStatusActive) {
+			keyring.PrimaryVersion = key.Version
+			break
+		}
+	}
+	return keyring, nil
+}
+
+// ListKeyrings returns every named key without its versions.
+func (db *DB) ListKeyrings(ctx context.Context) ([]*model.Keyring, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+keyringColumns+` FROM keyrings ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keyrings []*model.Keyring
+	for rows.Next() {
+		keyring, err := scanKeyring(rows)
+		if err != nil {
+			return nil, err
+		}
+		keyrings = append(keyrings, keyring)
+	}
+	return keyrings, rows.Err()
+}
+
+func (db *DB) UpdateRotationPeriod(ctx context.Context, name string, days int) error {
+	res, err := db.ExecContext(ctx,
+		`UPDATE keyrings SET rotation_period_days = $1 WHERE name = $2`, days, name)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const keyringColumns = `id, name, purpose, creation_date, rotation_period_days`
+
+func scanKeyring(row scanner) (*model.Keyring, error) {
+	var keyring model.Keyring
+	err := row.Scan(&keyring.ID, &keyring.Name, &keyring.Purpose, &keyring.CreationDate, &keyring.RotationPeriodDays)
+	if err != nil {
+		return nil, err
+	}
+	return &keyring, nil
+}
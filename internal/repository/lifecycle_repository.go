@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/valu/encrpytion/internal/model"
+)
+
+var ErrDeletionPending = errors.New("key version cannot be destroyed before its deletion date")
+
+// transitionKey locks one version of a key and moves it to the status returned by next.
+// The transition is checked against the model's state machine before it is written.
+func (db *DB) transitionKey(ctx context.Context, name string, version int,
+	next func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error)) (*model.EncryptionKey, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	key, err := scanKey(tx.QueryRowContext(ctx, `
+		SELECT `+keyColumns+`
+		FROM encryption_keys
+		WHERE key_name = $1 AND version = $2
+		FOR UPDATE`, name, version))
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := next(tx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := model.ValidateTransition(model.KeyStatus(key.Status), status); err != nil {
+		return nil, err
+	}
+	key.Status = string(status)
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE encryption_keys SET status = $1, deletion_date = $2, encrypted_key_material = $3
+		WHERE id = $4`,
+		key.Status, key.DeletionDate, key.EncryptedKeyMaterial, key.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := notifyKeyChange(ctx, tx, name); err != nil {
+		return nil, err
+	}
+
+	return key, tx.Commit()
+}
+
+// DisableKeyVersion makes a version unusable for both encryption and decryption.
+func (db *DB) DisableKeyVersion(ctx context.Context, name string, version int) (*model.EncryptionKey, error) {
+	return db.transitionKey(ctx, name, version, func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error) {
+		return model.KeyStatusDisabled, nil
+	})
+}
+
+// EnableKeyVersion brings a disabled version back, it can decrypt again.
+func (db *DB) EnableKeyVersion(ctx context.Context, name string, version int) (*model.EncryptionKey, error) {
+	return db.transitionKey(ctx, name, version, func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error) {
+		if model.KeyStatus(key.Status) != model.KeyStatusDisabled {
+			return "", model.ValidateTransition(model.KeyStatus(key.Status), model.KeyStatusRotated)
+		}
+		return model.KeyStatusRotated, nil
+	})
+}
+
+// ScheduleKeyVersionDeletion makes a version unusable and allows it to be destroyed after deletionDate.
+func (db *DB) ScheduleKeyVersionDeletion(ctx context.Context, name string, version int, deletionDate time.Time) (*model.EncryptionKey, error) {
+	return db.transitionKey(ctx, name, version, func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error) {
+		key.DeletionDate = &deletionDate
+		return model.KeyStatusScheduledForDeletion, nil
+	})
+}
+
+// CancelKeyVersionDeletion leaves a scheduled version disabled, it has to be enabled explicitly.
+func (db *DB) CancelKeyVersionDeletion(ctx context.Context, name string, version int) (*model.EncryptionKey, error) {
+	return db.transitionKey(ctx, name, version, func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error) {
+		if model.KeyStatus(key.Status) != model.KeyStatusScheduledForDeletion {
+			return "", model.ValidateTransition(model.KeyStatus(key.Status), model.KeyStatusDisabled)
+		}
+		key.DeletionDate = nil
+		return model.KeyStatusDisabled, nil
+	})
+}
+
+// DestroyKeyVersion erases the key material of a version whose deletion date has passed.
+// The metadata row is kept so ciphertexts under this version fail with a clear error:
+// everything encrypted with it is now unrecoverable.
+func (db *DB) DestroyKeyVersion(ctx context.Context, name string, version int, now time.Time) (*model.EncryptionKey, error) {
+	return db.transitionKey(ctx, name, version, func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error) {
+		if model.KeyStatus(key.Status) == model.KeyStatusScheduledForDeletion &&
+			key.DeletionDate != nil && now.Before(*key.DeletionDate) {
+			return "", ErrDeletionPending
+		}
+		key.EncryptedKeyMaterial = []byte{}
+		return model.KeyStatusDestroyed, nil
+	})
+}
+
+// ListDueDeletions returns versions scheduled for deletion whose deletion date has passed.
+func (db *DB) ListDueDeletions(ctx context.Context, now time.Time) ([]*model.EncryptionKey, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+keyColumns+`
+		FROM encryption_keys
+		WHERE status = 'SCHEDULED_FOR_DELETION' AND deletion_date <= $1
+		ORDER BY deletion_date`, now)
+	if err != nil {
+		return nil, err
+	}
+	return scanKeys(rows)
+}
@@ -0,0 +1,489 @@
+// Package memory implements repository.KeyStore in process memory. It is meant for
+// development and tests: everything, including the seal config, is lost on restart.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+)
+
+// Store keeps keys, keyrings, API clients and the seal config in maps guarded by a
+// single mutex, so every operation is atomic like a Postgres transaction. Values are
+// copied in and out, callers never share memory with the store.
+type Store struct {
+	mu         sync.RWMutex
+	keyrings   map[string]*model.Keyring
+	keys       map[uuid.UUID]*model.EncryptionKey
+	clients    map[uuid.UUID]*model.APIClient
+	sealConfig *model.SealConfig
+	nextID     int64
+	onChange   []func(name string)
+}
+
+var _ repository.KeyStore = (*Store)(nil)
+
+func New() *Store {
+	return &Store{
+		keyrings: make(map[string]*model.Keyring),
+		keys:     make(map[uuid.UUID]*model.EncryptionKey),
+		clients:  make(map[uuid.UUID]*model.APIClient),
+	}
+}
+
+// OnKeyChange registers fn to be called with the name of a key whenever one of its
+// versions is created or changes status. It is the in-memory counterpart of the
+// Postgres key change notifications. fn is called without the store lock held.
+func (s *Store) OnKeyChange(fn func(name string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+func (s *Store) notify(name string) {
+	s.mu.RLock()
+	listeners := s.onChange
+	s.mu.RUnlock()
+	for _, fn := range listeners {
+		fn(name)
+	}
+}
+
+func (s *Store) CreateKey(ctx context.Context, key *model.EncryptionKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.insertKey(key)
+}
+
+func (s *Store) GetKey(ctx context.Context, keyID uuid.UUID) (*model.EncryptionKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[keyID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyKey(key), nil
+}
+
+func (s *Store) ListActiveKeys(ctx context.Context) ([]*model.EncryptionKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []*model.EncryptionKey
+	for _, key := range s.keys {
+		if key.Status == string(model.KeyStatusActive) {
+			keys = append(keys, copyKey(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreationDate.After(keys[j].CreationDate) })
+	return keys, nil
+}
+
+func (s *Store) GetAllKeyVersions(ctx context.Context, name string) (map[uint32]*model.EncryptionKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keyVersions := make(map[uint32]*model.EncryptionKey)
+	for _, key := range s.versions(name) {
+		keyVersions[uint32(key.Version)] = copyKey(key)
+	}
+	return keyVersions, nil
+}
+
+func (s *Store) GetCurrentActiveKey(ctx context.Context, name string) (*model.EncryptionKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key := s.primary(name)
+	if key == nil {
+		return nil, sql.ErrNoRows
+	}
+	return copyKey(key), nil
+}
+
+// RotateKey has the same compare-and-swap semantics as the Postgres implementation.
+func (s *Store) RotateKey(ctx context.Context, name string, expectedVersion int, newKey *model.EncryptionKey) error {
+	err := func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, ok := s.keyrings[name]; !ok {
+			return sql.ErrNoRows
+		}
+		if _, ok := s.keys[newKey.KeyID]; ok {
+			return fmt.Errorf("key id %s already exists", newKey.KeyID)
+		}
+		var currentVersion, maxVersion int
+		if current := s.primary(name); current != nil {
+			currentVersion = current.Version
+		}
+		if currentVersion != expectedVersion {
+			return &repository.VersionConflictError{CurrentVersion: currentVersion}
+		}
+		versions := s.versions(name)
+		if len(versions) > 0 {
+			maxVersion = versions[len(versions)-1].Version
+		}
+
+		for _, key := range versions {
+			if key.Status == string(model.KeyStatusActive) {
+				key.Status = string(model.KeyStatusRotated)
+			}
+		}
+		newKey.Name = name
+		newKey.Version = maxVersion + 1
+		return s.insertKey(newKey)
+	}()
+	if err != nil {
+		return err
+	}
+	s.notify(name)
+	return nil
+}
+
+// RewrapLegacyKeys has nothing to do, keys created in memory are always wrapped.
+func (s *Store) RewrapLegacyKeys(ctx context.Context, wrap func(key *model.EncryptionKey) ([]byte, error)) (int, error) {
+	return 0, nil
+}
+
+func (s *Store) CreateKeyring(ctx context.Context, keyring *model.Keyring, firstKey *model.EncryptionKey) error {
+	err := func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, ok := s.keyrings[keyring.Name]; ok {
+			return repository.ErrKeyringExists
+		}
+		s.nextID++
+		keyring.ID = s.nextID
+		s.keyrings[keyring.Name] = &model.Keyring{
+			ID:                 keyring.ID,
+			Name:               keyring.Name,
+			Purpose:            keyring.Purpose,
+			CreationDate:       keyring.CreationDate,
+			RotationPeriodDays: keyring.RotationPeriodDays,
+		}
+		if err := s.insertKey(firstKey); err != nil {
+			delete(s.keyrings, keyring.Name)
+			return err
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+	s.notify(keyring.Name)
+	return nil
+}
+
+func (s *Store) GetKeyring(ctx context.Context, name string) (*model.Keyring, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stored, ok := s.keyrings[name]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	keyring := *stored
+
+	versions := s.versions(name)
+	for i := len(versions) - 1; i >= 0; i-- {
+		keyring.Versions = append(keyring.Versions, copyKey(versions[i]))
+	}
+	if primary := s.primary(name); primary != nil {
+		keyring.PrimaryVersion = primary.Version
+	}
+	return &keyring, nil
+}
+
+func (s *Store) ListKeyrings(ctx context.Context) ([]*model.Keyring, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keyrings []*model.Keyring
+	for _, stored := range s.keyrings {
+		keyring := *stored
+		keyrings = append(keyrings, &keyring)
+	}
+	sort.Slice(keyrings, func(i, j int) bool { return keyrings[i].Name < keyrings[j].Name })
+	return keyrings, nil
+}
+
+func (s *Store) UpdateRotationPeriod(ctx context.Context, name string, days int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keyring, ok := s.keyrings[name]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	keyring.RotationPeriodDays = days
+	return nil
+}
+
+func (s *Store) DisableKeyVersion(ctx context.Context, name string, version int) (*model.EncryptionKey, error) {
+	return s.transitionKey(name, version, func(key *model.EncryptionKey) (model.KeyStatus, error) {
+		return model.KeyStatusDisabled, nil
+	})
+}
+
+func (s *Store) EnableKeyVersion(ctx context.Context, name string, version int) (*model.EncryptionKey, error) {
+	return s.transitionKey(name, version, func(key *model.EncryptionKey) (model.KeyStatus, error) {
+		if model.KeyStatus(key.Status) != model.KeyStatusDisabled {
+			return "", model.ValidateTransition(model.KeyStatus(key.Status), model.KeyStatusRotated)
+		}
+		return model.KeyStatusRotated, nil
+	})
+}
+
+func (s *Store) ScheduleKeyVersionDeletion(ctx context.Context, name string, version int, deletionDate time.Time) (*model.EncryptionKey, error) {
+	return s.transitionKey(name, version, func(key *model.EncryptionKey) (model.KeyStatus, error) {
+		key.DeletionDate = &deletionDate
+		return model.KeyStatusScheduledForDeletion, nil
+	})
+}
+
+func (s *Store) CancelKeyVersionDeletion(ctx context.Context, name string, version int) (*model.EncryptionKey, error) {
+	return s.transitionKey(name, version, func(key *model.EncryptionKey) (model.KeyStatus, error) {
+		if model.KeyStatus(key.Status) != model.KeyStatusScheduledForDeletion {
+			return "", model.ValidateTransition(model.KeyStatus(key.Status), model.KeyStatusDisabled)
+		}
+		key.DeletionDate = nil
+		return model.KeyStatusDisabled, nil
+	})
+}
+
+func (s *Store) DestroyKeyVersion(ctx context.Context, name string, version int, now time.Time) (*model.EncryptionKey, error) {
+	return s.transitionKey(name, version, func(key *model.EncryptionKey) (model.KeyStatus, error) {
+		if model.KeyStatus(key.Status) == model.KeyStatusScheduledForDeletion &&
+			key.DeletionDate != nil && now.Before(*key.DeletionDate) {
+			return "", repository.ErrDeletionPending
+		}
+		key.EncryptedKeyMaterial = []byte{}
+		return model.KeyStatusDestroyed, nil
+	})
+}
+
+func (s *Store) ListDueDeletions(ctx context.Context, now time.Time) ([]*model.EncryptionKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []*model.EncryptionKey
+	for _, key := range s.keys {
+		if key.Status == string(model.KeyStatusScheduledForDeletion) && key.DeletionDate != nil && !key.DeletionDate.After(now) {
+			keys = append(keys, copyKey(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].DeletionDate.Before(*keys[j].DeletionDate) })
+	return keys, nil
+}
+
+func (s *Store) GetSealConfig(ctx context.Context) (*model.SealConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.sealConfig == nil {
+		return nil, sql.ErrNoRows
+	}
+	cfg := *s.sealConfig
+	cfg.RootKeyCheck = append([]byte(nil), s.sealConfig.RootKeyCheck...)
+	return &cfg, nil
+}
+
+func (s *Store) CreateSealConfig(ctx context.Context, cfg *model.SealConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealConfig != nil {
+		return repository.ErrSealConfigExists
+	}
+	stored := *cfg
+	stored.RootKeyCheck = append([]byte(nil), cfg.RootKeyCheck...)
+	s.sealConfig = &stored
+	return nil
+}
+
+func (s *Store) CreateAPIClient(ctx context.Context, client *model.APIClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[client.ID]; ok {
+		return fmt.Errorf("api client %s already exists", client.ID)
+	}
+	if client.CertificateIdentity != "" && s.clientByIdentity(client.CertificateIdentity) != nil {
+		return repository.ErrIdentityTaken
+	}
+	s.clients[client.ID] = copyClient(client)
+	return nil
+}
+
+func (s *Store) GetAPIClient(ctx context.Context, id uuid.UUID) (*model.APIClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client, ok := s.clients[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyClient(client), nil
+}
+
+func (s *Store) GetAPIClientByIdentity(ctx context.Context, identity string) (*model.APIClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client := s.clientByIdentity(identity)
+	if client == nil {
+		return nil, sql.ErrNoRows
+	}
+	return copyClient(client), nil
+}
+
+// clientByIdentity must be called with mu held.
+func (s *Store) clientByIdentity(identity string) *model.APIClient {
+	for _, client := range s.clients {
+		if identity != "" && client.CertificateIdentity == identity && !client.Revoked() {
+			return client
+		}
+	}
+	return nil
+}
+
+func (s *Store) ListAPIClients(ctx context.Context) ([]*model.APIClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	clients := make([]*model.APIClient, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, copyClient(client))
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if !clients[i].CreationDate.Equal(clients[j].CreationDate) {
+			return clients[i].CreationDate.Before(clients[j].CreationDate)
+		}
+		return clients[i].Name < clients[j].Name
+	})
+	return clients, nil
+}
+
+func (s *Store) RevokeAPIClient(ctx context.Context, id uuid.UUID, now time.Time) (*model.APIClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clients[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if client.RevocationDate == nil {
+		client.RevocationDate = &now
+	}
+	return copyClient(client), nil
+}
+
+// transitionKey moves one version to the status returned by next, checked against the
+// model's state machine. next runs with the store lock held and may edit the copy it
+// receives, which replaces the stored version once the transition is valid.
+func (s *Store) transitionKey(name string, version int,
+	next func(key *model.EncryptionKey) (model.KeyStatus, error)) (*model.EncryptionKey, error) {
+	key, err := func() (*model.EncryptionKey, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		var stored *model.EncryptionKey
+		for _, key := range s.versions(name) {
+			if key.Version == version {
+				stored = key
+			}
+		}
+		if stored == nil {
+			return nil, sql.ErrNoRows
+		}
+
+		key := copyKey(stored)
+		status, err := next(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := model.ValidateTransition(model.KeyStatus(key.Status), status); err != nil {
+			return nil, err
+		}
+		if status == model.KeyStatusActive {
+			if primary := s.primary(name); primary != nil && primary.KeyID != key.KeyID {
+				return nil, fmt.Errorf("key %q already has an active version", name)
+			}
+		}
+		key.Status = string(status)
+		s.keys[key.KeyID] = key
+		return copyKey(key), nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+	s.notify(name)
+	return key, nil
+}
+
+// insertKey enforces the same constraints as the Postgres schema: the keyring must
+// exist, and key ids, versions and the single ACTIVE version per key are unique.
+// The caller holds the write lock.
+func (s *Store) insertKey(key *model.EncryptionKey) error {
+	if _, ok := s.keyrings[key.Name]; !ok {
+		return fmt.Errorf("keyring %q does not exist", key.Name)
+	}
+	if _, ok := s.keys[key.KeyID]; ok {
+		return fmt.Errorf("key id %s already exists", key.KeyID)
+	}
+	for _, existing := range s.versions(key.Name) {
+		if existing.Version == key.Version {
+			return fmt.Errorf("version %d of key %q already exists", key.Version, key.Name)
+		}
+		if existing.Status == string(model.KeyStatusActive) && key.Status == string(model.KeyStatusActive) {
+			return fmt.Errorf("key %q already has an active version", key.Name)
+		}
+	}
+
+	s.nextID++
+	key.ID = s.nextID
+	s.keys[key.KeyID] = copyKey(key)
+	return nil
+}
+
+// versions returns the stored versions of the named key in ascending order. The caller
+// holds the lock.
+func (s *Store) versions(name string) []*model.EncryptionKey {
+	var versions []*model.EncryptionKey
+	for _, key := range s.keys {
+		if key.Name == name {
+			versions = append(versions, key)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions
+}
+
+// primary returns the highest ACTIVE version of the named key, or nil. The caller holds the lock.
+func (s *Store) primary(name string) *model.EncryptionKey {
+	versions := s.versions(name)
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Status == string(model.KeyStatusActive) {
+			return versions[i]
+		}
+	}
+	return nil
+}
+
+func copyKey(key *model.EncryptionKey) *model.EncryptionKey {
+	c := *key
+	c.EncryptedKeyMaterial = append([]byte{}, key.EncryptedKeyMaterial...)
+	if key.PublicKey != nil {
+		c.PublicKey = append([]byte{}, key.PublicKey...)
+	}
+	if key.DeletionDate != nil {
+		deletionDate := *key.DeletionDate
+		c.DeletionDate = &deletionDate
+	}
+	return &c
+}
+
+func copyClient(client *model.APIClient) *model.APIClient {
+	c := *client
+	c.Scopes = append([]model.Scope(nil), client.Scopes...)
+	if client.RevocationDate != nil {
+		revocationDate := *client.RevocationDate
+		c.RevocationDate = &revocationDate
+	}
+	return &c
+}
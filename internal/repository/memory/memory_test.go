@@ -0,0 +1,13 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/repository/memory"
+	"github.com/valu/encrpytion/internal/repository/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.TestKeyStore(t, func(*testing.T) repository.KeyStore { return memory.New() })
+}
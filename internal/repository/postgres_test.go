@@ -0,0 +1,45 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/repository/storetest"
+	"github.com/valu/encrpytion/migrations"
+)
+
+// TestPostgresStore runs the conformance suite against the Postgres database in
+// TEST_DB_URL. Every table is truncated before each subtest, so it must be a scratch
+// database.
+func TestPostgresStore(t *testing.T) {
+	dbUrl := os.Getenv("TEST_DB_URL")
+	if dbUrl == "" {
+		t.Skip("TEST_DB_URL is not set")
+	}
+
+	db, err := sql.Open("pgx", dbUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	provider, err := migrations.NewProvider(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Up(context.Background()); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+
+	storetest.TestKeyStore(t, func(t *testing.T) repository.KeyStore {
+		_, err := db.Exec(`TRUNCATE encryption_keys, keyrings, seal_config, rewrap_jobs, api_clients CASCADE`)
+		if err != nil {
+			t.Fatalf("failed to truncate tables: %v", err)
+		}
+		return repository.New(db)
+	})
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// GetSealConfig returns sql.ErrNoRows when the keystore has not been initialized.
+func (db *DB) GetSealConfig(ctx context.Context) (*model.SealConfig, error) {
+	query := `
+		SELECT secret_shares, secret_threshold, root_key_check, creation_date
+		FROM seal_config
+		WHERE id = 1`
+	var cfg model.SealConfig
+	err := db.QueryRowContext(ctx, query).Scan(
+		&cfg.SecretShares, &cfg.SecretThreshold, &cfg.RootKeyCheck, &cfg.CreationDate,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+var ErrSealConfigExists = errors.New("the keystore has already been initialized")
+
+// CreateSealConfig returns ErrSealConfigExists when the keystore was initialized before.
+func (db *DB) CreateSealConfig(ctx context.Context, cfg *model.SealConfig) error {
+	query := `
+		INSERT INTO seal_config (id, secret_shares, secret_threshold, root_key_check, creation_date)
+		VALUES (1, $1, $2, $3, $4)`
+	_, err := db.ExecContext(ctx, query,
+		cfg.SecretShares, cfg.SecretThreshold, cfg.RootKeyCheck, cfg.CreationDate,
+	)
+	if isUniqueViolation(err) {
+		return ErrSealConfigExists
+	}
+	return err
+}
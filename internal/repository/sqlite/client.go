@@ -0,0 +1,74 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+)
+
+const clientColumns = `id, name, scopes, secret_hash, certificate_identity, creation_date, revocation_date`
+
+func scanClient(row scanner) (*model.APIClient, error) {
+	var client model.APIClient
+	var scopes string
+	var identity sql.NullString
+	err := row.Scan(&client.ID, &client.Name, &scopes, &client.SecretHash, &identity, &client.CreationDate, &client.RevocationDate)
+	if err != nil {
+		return nil, err
+	}
+	client.Scopes = repository.DecodeScopes(scopes)
+	client.CertificateIdentity = identity.String
+	return &client, nil
+}
+
+func (s *Store) CreateAPIClient(ctx context.Context, client *model.APIClient) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO api_clients (id, name, scopes, secret_hash, certificate_identity, creation_date)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		client.ID, client.Name, repository.EncodeScopes(client.Scopes), client.SecretHash,
+		repository.NullString(client.CertificateIdentity), client.CreationDate.UTC(),
+	)
+	if client.CertificateIdentity != "" && isUniqueViolation(err) {
+		return repository.ErrIdentityTaken
+	}
+	return err
+}
+
+func (s *Store) GetAPIClient(ctx context.Context, id uuid.UUID) (*model.APIClient, error) {
+	return scanClient(s.db.QueryRowContext(ctx, `SELECT `+clientColumns+` FROM api_clients WHERE id = ?`, id))
+}
+
+func (s *Store) GetAPIClientByIdentity(ctx context.Context, identity string) (*model.APIClient, error) {
+	return scanClient(s.db.QueryRowContext(ctx, `
+		SELECT `+clientColumns+` FROM api_clients
+		WHERE certificate_identity = ? AND revocation_date IS NULL`, identity))
+}
+
+func (s *Store) ListAPIClients(ctx context.Context) ([]*model.APIClient, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+clientColumns+` FROM api_clients ORDER BY creation_date, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var clients []*model.APIClient
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+func (s *Store) RevokeAPIClient(ctx context.Context, id uuid.UUID, now time.Time) (*model.APIClient, error) {
+	return scanClient(s.db.QueryRowContext(ctx, `
+		UPDATE api_clients
+		SET revocation_date = COALESCE(revocation_date, ?)
+		WHERE id = ?
+		RETURNING `+clientColumns, now.UTC(), id))
+}
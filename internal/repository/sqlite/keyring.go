@@ -0,0 +1,111 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+)
+
+const keyringColumns = `id, name, purpose, creation_date, rotation_period_days`
+
+func scanKeyring(row scanner) (*model.Keyring, error) {
+	var keyring model.Keyring
+	err := row.Scan(&keyring.ID, &keyring.Name, &keyring.Purpose, &keyring.CreationDate, &keyring.RotationPeriodDays)
+	if err != nil {
+		return nil, err
+	}
+	return &keyring, nil
+}
+
+func (s *Store) CreateKeyring(ctx context.Context, keyring *model.Keyring, firstKey *model.EncryptionKey) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO keyrings (name, purpose, creation_date, rotation_period_days) VALUES (?, ?, ?, ?) RETURNING id`,
+		keyring.Name, keyring.Purpose, keyring.CreationDate.UTC(), keyring.RotationPeriodDays,
+	).Scan(&keyring.ID)
+	if isUniqueViolation(err) {
+		return repository.ErrKeyringExists
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := insertKey(ctx, tx, firstKey); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.notify(keyring.Name)
+	return nil
+}
+
+func (s *Store) GetKeyring(ctx context.Context, name string) (*model.Keyring, error) {
+	keyring, err := scanKeyring(s.db.QueryRowContext(ctx,
+		`SELECT `+keyringColumns+` FROM keyrings WHERE name = ?`, name,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+keyColumns+`
+		FROM encryption_keys
+		WHERE key_name = ?
+		ORDER BY version DESC`, name)
+	if err != nil {
+		return nil, err
+	}
+	keyring.Versions, err = scanKeys(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keyring.Versions {
+		if key.Status == string(model.KeyStatusActive) {
+			keyring.PrimaryVersion = key.Version
+			break
+		}
+	}
+	return keyring, nil
+}
+
+func (s *Store) ListKeyrings(ctx context.Context) ([]*model.Keyring, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+keyringColumns+` FROM keyrings ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keyrings []*model.Keyring
+	for rows.Next() {
+		keyring, err := scanKeyring(rows)
+		if err != nil {
+			return nil, err
+		}
+		keyrings = append(keyrings, keyring)
+	}
+	return keyrings, rows.Err()
+}
+
+func (s *Store) UpdateRotationPeriod(ctx context.Context, name string, days int) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE keyrings SET rotation_period_days = ? WHERE name = ?`, days, name)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+)
+
+// transitionKey moves one version of a key to the status returned by next, checked
+// against the model's state machine, like its Postgres counterpart.
+func (s *Store) transitionKey(ctx context.Context, name string, version int,
+	next func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error)) (*model.EncryptionKey, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	key, err := scanKey(tx.QueryRowContext(ctx, `
+		SELECT `+keyColumns+`
+		FROM encryption_keys
+		WHERE key_name = ? AND version = ?`, name, version))
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := next(tx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := model.ValidateTransition(model.KeyStatus(key.Status), status); err != nil {
+		return nil, err
+	}
+	key.Status = string(status)
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE encryption_keys SET status = ?, deletion_date = ?, encrypted_key_material = ?
+		WHERE id = ?`,
+		key.Status, key.DeletionDate, key.EncryptedKeyMaterial, key.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.notify(name)
+	return key, nil
+}
+
+func (s *Store) DisableKeyVersion(ctx context.Context, name string, version int) (*model.EncryptionKey, error) {
+	return s.transitionKey(ctx, name, version, func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error) {
+		return model.KeyStatusDisabled, nil
+	})
+}
+
+func (s *Store) EnableKeyVersion(ctx context.Context, name string, version int) (*model.EncryptionKey, error) {
+	return s.transitionKey(ctx, name, version, func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error) {
+		if model.KeyStatus(key.Status) != model.KeyStatusDisabled {
+			return "", model.ValidateTransition(model.KeyStatus(key.Status), model.KeyStatusRotated)
+		}
+		return model.KeyStatusRotated, nil
+	})
+}
+
+func (s *Store) ScheduleKeyVersionDeletion(ctx context.Context, name string, version int, deletionDate time.Time) (*model.EncryptionKey, error) {
+	return s.transitionKey(ctx, name, version, func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error) {
+		deletionDate := deletionDate.UTC()
+		key.DeletionDate = &deletionDate
+		return model.KeyStatusScheduledForDeletion, nil
+	})
+}
+
+func (s *Store) CancelKeyVersionDeletion(ctx context.Context, name string, version int) (*model.EncryptionKey, error) {
+	return s.transitionKey(ctx, name, version, func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error) {
+		if model.KeyStatus(key.Status) != model.KeyStatusScheduledForDeletion {
+			return "", model.ValidateTransition(model.KeyStatus(key.Status), model.KeyStatusDisabled)
+		}
+		key.DeletionDate = nil
+		return model.KeyStatusDisabled, nil
+	})
+}
+
+func (s *Store) DestroyKeyVersion(ctx context.Context, name string, version int, now time.Time) (*model.EncryptionKey, error) {
+	return s.transitionKey(ctx, name, version, func(tx *sql.Tx, key *model.EncryptionKey) (model.KeyStatus, error) {
+		if model.KeyStatus(key.Status) == model.KeyStatusScheduledForDeletion &&
+			key.DeletionDate != nil && now.Before(*key.DeletionDate) {
+			return "", repository.ErrDeletionPending
+		}
+		key.EncryptedKeyMaterial = []byte{}
+		return model.KeyStatusDestroyed, nil
+	})
+}
+
+func (s *Store) ListDueDeletions(ctx context.Context, now time.Time) ([]*model.EncryptionKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+keyColumns+`
+		FROM encryption_keys
+		WHERE status = 'SCHEDULED_FOR_DELETION' AND deletion_date <= ?
+		ORDER BY deletion_date`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	return scanKeys(rows)
+}
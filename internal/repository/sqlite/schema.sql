@@ -0,0 +1,46 @@
+-- The SQLite counterpart of the Postgres migrations in migrations/, applied when the
+-- store is opened. Rewrap jobs are Postgres only and have no table here.
+CREATE TABLE IF NOT EXISTS keyrings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    purpose VARCHAR(32) NOT NULL DEFAULT 'encrypt',
+    creation_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    rotation_period_days INTEGER NOT NULL DEFAULT 0 CHECK (rotation_period_days >= 0)
+);
+
+CREATE TABLE IF NOT EXISTS encryption_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    key_id TEXT NOT NULL UNIQUE,
+    key_name VARCHAR(64) NOT NULL REFERENCES keyrings (name),
+    encrypted_key_material BLOB NOT NULL,
+    creation_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expiration_date TIMESTAMP,
+    deletion_date TIMESTAMP,
+    status VARCHAR(32) CHECK (status IN ('PENDING', 'ACTIVE', 'ROTATED', 'DISABLED', 'SCHEDULED_FOR_DELETION', 'DESTROYED')),
+    version INTEGER NOT NULL,
+    algorithm VARCHAR(32) NOT NULL DEFAULT 'AES256_GCM',
+    public_key BLOB
+);
+CREATE UNIQUE INDEX IF NOT EXISTS encryption_keys_key_name_version_key ON encryption_keys (key_name, version);
+CREATE UNIQUE INDEX IF NOT EXISTS encryption_keys_one_active_key ON encryption_keys (key_name) WHERE status = 'ACTIVE';
+
+CREATE TABLE IF NOT EXISTS seal_config (
+    id INTEGER PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    secret_shares INTEGER NOT NULL,
+    secret_threshold INTEGER NOT NULL,
+    root_key_check BLOB NOT NULL,
+    creation_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS api_clients (
+    id TEXT PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    scopes TEXT NOT NULL,
+    secret_hash TEXT NOT NULL,
+    certificate_identity TEXT,
+    creation_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    revocation_date TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS api_clients_certificate_identity_key ON api_clients (certificate_identity)
+    WHERE certificate_identity IS NOT NULL AND revocation_date IS NULL;
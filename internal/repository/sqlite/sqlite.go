@@ -0,0 +1,291 @@
+// Package sqlite implements repository.KeyStore on a single SQLite file, for edge
+// deployments and local development without a Postgres server.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+	sqlite "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+//go:embed schema.sql
+var schema string
+
+// Store keeps keys in one SQLite file. It uses a single connection, so transactions are
+// serialized in process the way row locks serialize them in Postgres. Timestamps are
+// stored as UTC text, which keeps comparisons in SQL correct.
+type Store struct {
+	db *sql.DB
+
+	mu       sync.Mutex
+	onChange []func(name string)
+}
+
+var _ repository.KeyStore = (*Store)(nil)
+
+// Open opens or creates the database file at path and applies the schema.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	// Columns are added first, schema.sql may index them.
+	if err := addMissingColumns(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to apply schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// addedColumns were added to schema.sql after its tables were first released. CREATE
+// TABLE IF NOT EXISTS leaves the tables of an existing file alone, so Open adds them.
+// Tables that do not exist yet are skipped, schema.sql creates them whole.
+var addedColumns = []struct{ table, column, definition string }{
+	{"encryption_keys", "algorithm", "VARCHAR(32) NOT NULL DEFAULT 'AES256_GCM'"},
+	{"keyrings", "purpose", "VARCHAR(32) NOT NULL DEFAULT 'encrypt'"},
+	{"encryption_keys", "public_key", "BLOB"},
+	{"api_clients", "certificate_identity", "TEXT"},
+}
+
+func addMissingColumns(db *sql.DB) error {
+	for _, c := range addedColumns {
+		var tableExists, exists bool
+		err := db.QueryRow(`SELECT COUNT(*) > 0, COALESCE(SUM(name = ?), 0) > 0 FROM pragma_table_info(?)`, c.column, c.table).
+			Scan(&tableExists, &exists)
+		if err != nil {
+			return err
+		}
+		if !tableExists || exists {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE ` + c.table + ` ADD COLUMN ` + c.column + ` ` + c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// OnKeyChange registers fn to be called with the name of a key whenever one of its
+// versions is created or changes status, after the change is committed.
+func (s *Store) OnKeyChange(fn func(name string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+func (s *Store) notify(name string) {
+	s.mu.Lock()
+	listeners := s.onChange
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(name)
+	}
+}
+
+const keyColumns = `id, key_id, key_name, encrypted_key_material, creation_date, expiration_date, deletion_date, status, version, algorithm, public_key`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanKey(row scanner) (*model.EncryptionKey, error) {
+	var key model.EncryptionKey
+	err := row.Scan(
+		&key.ID, &key.KeyID, &key.Name, &key.EncryptedKeyMaterial, &key.CreationDate, &key.ExpirationDate, &key.DeletionDate,
+		&key.Status, &key.Version, &key.Algorithm, &key.PublicKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func scanKeys(rows *sql.Rows) ([]*model.EncryptionKey, error) {
+	defer rows.Close()
+	var keys []*model.EncryptionKey
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code()
+	return code == sqlite3.SQLITE_CONSTRAINT_UNIQUE || code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+func insertKey(ctx context.Context, tx *sql.Tx, key *model.EncryptionKey) error {
+	return tx.QueryRowContext(ctx,
+		`INSERT INTO encryption_keys (key_id, key_name, encrypted_key_material, creation_date, expiration_date, status, version, algorithm, public_key)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 RETURNING id`,
+		key.KeyID, key.Name, key.EncryptedKeyMaterial, key.CreationDate.UTC(), key.ExpirationDate.UTC(), key.Status, key.Version, key.Algorithm, key.PublicKey,
+	).Scan(&key.ID)
+}
+
+func (s *Store) CreateKey(ctx context.Context, key *model.EncryptionKey) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertKey(ctx, tx, key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) GetKey(ctx context.Context, keyID uuid.UUID) (*model.EncryptionKey, error) {
+	return scanKey(s.db.QueryRowContext(ctx, `SELECT `+keyColumns+` FROM encryption_keys WHERE key_id = ?`, keyID))
+}
+
+func (s *Store) ListActiveKeys(ctx context.Context) ([]*model.EncryptionKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+keyColumns+`
+		FROM encryption_keys
+		WHERE status = 'ACTIVE'
+		ORDER BY creation_date DESC`)
+	if err != nil {
+		return nil, err
+	}
+	return scanKeys(rows)
+}
+
+func (s *Store) GetAllKeyVersions(ctx context.Context, name string) (map[uint32]*model.EncryptionKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+keyColumns+`
+		FROM encryption_keys
+		WHERE key_name = ?
+		ORDER BY version`, name)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := scanKeys(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	keyVersions := make(map[uint32]*model.EncryptionKey, len(keys))
+	for _, key := range keys {
+		keyVersions[uint32(key.Version)] = key
+	}
+	return keyVersions, nil
+}
+
+func (s *Store) GetCurrentActiveKey(ctx context.Context, name string) (*model.EncryptionKey, error) {
+	return scanKey(s.db.QueryRowContext(ctx, `
+		SELECT `+keyColumns+`
+		FROM encryption_keys
+		WHERE key_name = ? AND status = 'ACTIVE'
+		ORDER BY version DESC
+		LIMIT 1`, name))
+}
+
+// RotateKey has the same compare-and-swap semantics as the Postgres implementation, the
+// single connection takes the place of the keyring row lock.
+func (s *Store) RotateKey(ctx context.Context, name string, expectedVersion int, newKey *model.EncryptionKey) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var keyringID int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM keyrings WHERE name = ?`, name).Scan(&keyringID); err != nil {
+		return err
+	}
+
+	var currentVersion, maxVersion int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(version) FILTER (WHERE status = 'ACTIVE'), 0), COALESCE(MAX(version), 0)
+		FROM encryption_keys
+		WHERE key_name = ?`, name,
+	).Scan(&currentVersion, &maxVersion)
+	if err != nil {
+		return err
+	}
+	if currentVersion != expectedVersion {
+		return &repository.VersionConflictError{CurrentVersion: currentVersion}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE encryption_keys SET status = ? WHERE key_name = ? AND status = ?`,
+		string(model.KeyStatusRotated), name, string(model.KeyStatusActive))
+	if err != nil {
+		return err
+	}
+
+	newKey.Name = name
+	newKey.Version = maxVersion + 1
+	err = insertKey(ctx, tx, newKey)
+	if isUniqueViolation(err) {
+		return &repository.VersionConflictError{CurrentVersion: currentVersion}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.notify(name)
+	return nil
+}
+
+// RewrapLegacyKeys has nothing to do, keys in a SQLite store were always wrapped.
+func (s *Store) RewrapLegacyKeys(ctx context.Context, wrap func(key *model.EncryptionKey) ([]byte, error)) (int, error) {
+	return 0, nil
+}
+
+func (s *Store) GetSealConfig(ctx context.Context) (*model.SealConfig, error) {
+	var cfg model.SealConfig
+	err := s.db.QueryRowContext(ctx, `
+		SELECT secret_shares, secret_threshold, root_key_check, creation_date
+		FROM seal_config
+		WHERE id = 1`,
+	).Scan(&cfg.SecretShares, &cfg.SecretThreshold, &cfg.RootKeyCheck, &cfg.CreationDate)
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (s *Store) CreateSealConfig(ctx context.Context, cfg *model.SealConfig) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO seal_config (id, secret_shares, secret_threshold, root_key_check, creation_date)
+		VALUES (1, ?, ?, ?, ?)`,
+		cfg.SecretShares, cfg.SecretThreshold, cfg.RootKeyCheck, cfg.CreationDate,
+	)
+	if isUniqueViolation(err) {
+		return repository.ErrSealConfigExists
+	}
+	return err
+}
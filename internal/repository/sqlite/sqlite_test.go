@@ -0,0 +1,21 @@
+package sqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/repository/sqlite"
+	"github.com/valu/encrpytion/internal/repository/storetest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.TestKeyStore(t, func(t *testing.T) repository.KeyStore {
+		store, err := sqlite.Open(filepath.Join(t.TempDir(), "keystore.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
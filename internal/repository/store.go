@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// KeyStore is everything the key handlers, the seal barrier, the rotation scheduler and
+// authentication need from storage. *DB implements it on Postgres; lookups that find
+// nothing return sql.ErrNoRows on every implementation so callers can treat them alike.
+type KeyStore interface {
+	CreateKey(ctx context.Context, key *model.EncryptionKey) error
+	GetKey(ctx context.Context, keyID uuid.UUID) (*model.EncryptionKey, error)
+	ListActiveKeys(ctx context.Context) ([]*model.EncryptionKey, error)
+	GetAllKeyVersions(ctx context.Context, name string) (map[uint32]*model.EncryptionKey, error)
+	GetCurrentActiveKey(ctx context.Context, name string) (*model.EncryptionKey, error)
+	RotateKey(ctx context.Context, name string, expectedVersion int, newKey *model.EncryptionKey) error
+	RewrapLegacyKeys(ctx context.Context, wrap func(key *model.EncryptionKey) ([]byte, error)) (int, error)
+
+	CreateKeyring(ctx context.Context, keyring *model.Keyring, firstKey *model.EncryptionKey) error
+	GetKeyring(ctx context.Context, name string) (*model.Keyring, error)
+	ListKeyrings(ctx context.Context) ([]*model.Keyring, error)
+	UpdateRotationPeriod(ctx context.Context, name string, days int) error
+
+	DisableKeyVersion(ctx context.Context, name string, version int) (*model.EncryptionKey, error)
+	EnableKeyVersion(ctx context.Context, name string, version int) (*model.EncryptionKey, error)
+	ScheduleKeyVersionDeletion(ctx context.Context, name string, version int, deletionDate time.Time) (*model.EncryptionKey, error)
+	CancelKeyVersionDeletion(ctx context.Context, name string, version int) (*model.EncryptionKey, error)
+	DestroyKeyVersion(ctx context.Context, name string, version int, now time.Time) (*model.EncryptionKey, error)
+	ListDueDeletions(ctx context.Context, now time.Time) ([]*model.EncryptionKey, error)
+
+	GetSealConfig(ctx context.Context) (*model.SealConfig, error)
+	CreateSealConfig(ctx context.Context, cfg *model.SealConfig) error
+
+	CreateAPIClient(ctx context.Context, client *model.APIClient) error
+	GetAPIClient(ctx context.Context, id uuid.UUID) (*model.APIClient, error)
+	GetAPIClientByIdentity(ctx context.Context, identity string) (*model.APIClient, error)
+	ListAPIClients(ctx context.Context) ([]*model.APIClient, error)
+	RevokeAPIClient(ctx context.Context, id uuid.UUID, now time.Time) (*model.APIClient, error)
+}
+
+var _ KeyStore = (*DB)(nil)
+
+// KeyChangeNotifier is implemented by stores that live in a single process. They call
+// the registered functions with the name of a key once one of its versions was created
+// or changed status; Postgres replicas learn about changes through KeyChangesChannel.
+type KeyChangeNotifier interface {
+	OnKeyChange(fn func(name string))
+}
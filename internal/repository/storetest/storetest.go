@@ -0,0 +1,485 @@
+// Package storetest is a conformance suite for repository.KeyStore implementations.
+// A backend's tests call TestKeyStore with a constructor for empty stores, for example:
+//
+//	func TestMemoryStore(t *testing.T) {
+//		storetest.TestKeyStore(t, func(t *testing.T) repository.KeyStore { return memory.New() })
+//	}
+//
+// The Postgres run migrates the scratch database in TEST_DB_URL and truncates every
+// table, see postgres_test.go in package repository.
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+)
+
+// TestKeyStore runs the suite. newStore is called once per subtest and must return an
+// empty store.
+func TestKeyStore(t *testing.T, newStore func(t *testing.T) repository.KeyStore) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store repository.KeyStore)
+	}{
+		{"Keyrings", testKeyrings},
+		{"GetKey", testGetKey},
+		{"CreateKey", testCreateKey},
+		{"RotateKey", testRotateKey},
+		{"RotateKeyConflict", testRotateKeyConflict},
+		{"DisableAndEnable", testDisableAndEnable},
+		{"ScheduledDeletion", testScheduledDeletion},
+		{"InvalidTransitions", testInvalidTransitions},
+		{"SealConfig", testSealConfig},
+		{"APIClients", testAPIClients},
+		{"Isolation", testIsolation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t))
+		})
+	}
+}
+
+func newKey(t *testing.T, name string, version int) *model.EncryptionKey {
+	t.Helper()
+	material := make([]byte, 60)
+	if _, err := rand.Read(material); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	return &model.EncryptionKey{
+		KeyID:                uuid.New(),
+		Name:                 name,
+		EncryptedKeyMaterial: material,
+		CreationDate:         now,
+		ExpirationDate:       now.AddDate(1, 0, 0),
+		Status:               string(model.KeyStatusActive),
+		Version:              version,
+		Algorithm:            "CHACHA20_POLY1305",
+		PublicKey:            []byte("public key"),
+	}
+}
+
+// createKeyring creates a keyring with one ACTIVE version and returns that version.
+func createKeyring(t *testing.T, store repository.KeyStore, name string) *model.EncryptionKey {
+	t.Helper()
+	key := newKey(t, name, 1)
+	keyring := &model.Keyring{Name: name, Purpose: model.KeyPurposeEncrypt, CreationDate: key.CreationDate, RotationPeriodDays: 30}
+	if err := store.CreateKeyring(context.Background(), keyring, key); err != nil {
+		t.Fatalf("CreateKeyring(%q): %v", name, err)
+	}
+	return key
+}
+
+// rotate rotates the named key away from expectedVersion and returns the new version.
+func rotate(t *testing.T, store repository.KeyStore, name string, expectedVersion int) *model.EncryptionKey {
+	t.Helper()
+	key := newKey(t, name, 0)
+	if err := store.RotateKey(context.Background(), name, expectedVersion, key); err != nil {
+		t.Fatalf("RotateKey(%q, %d): %v", name, expectedVersion, err)
+	}
+	return key
+}
+
+func wantStatus(t *testing.T, store repository.KeyStore, key *model.EncryptionKey, status model.KeyStatus) {
+	t.Helper()
+	got, err := store.GetKey(context.Background(), key.KeyID)
+	if err != nil {
+		t.Fatalf("GetKey(%s): %v", key.KeyID, err)
+	}
+	if got.Status != string(status) {
+		t.Fatalf("version %d of %q is %s, want %s", got.Version, got.Name, got.Status, status)
+	}
+}
+
+func testKeyrings(t *testing.T, store repository.KeyStore) {
+	ctx := context.Background()
+	first := createKeyring(t, store, "payments")
+	createKeyring(t, store, "billing")
+
+	err := store.CreateKeyring(ctx, &model.Keyring{Name: "payments", CreationDate: time.Now()}, newKey(t, "payments", 1))
+	if !errors.Is(err, repository.ErrKeyringExists) {
+		t.Fatalf("creating a duplicate keyring returned %v, want ErrKeyringExists", err)
+	}
+
+	keyring, err := store.GetKeyring(ctx, "payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.PrimaryVersion != 1 || len(keyring.Versions) != 1 || keyring.Versions[0].KeyID != first.KeyID {
+		t.Fatalf("unexpected keyring %+v", keyring)
+	}
+	if keyring.RotationPeriodDays != 30 {
+		t.Fatalf("rotation period is %d, want 30", keyring.RotationPeriodDays)
+	}
+	if keyring.Purpose != model.KeyPurposeEncrypt {
+		t.Fatalf("purpose is %q, want %q", keyring.Purpose, model.KeyPurposeEncrypt)
+	}
+
+	if err := store.UpdateRotationPeriod(ctx, "payments", 90); err != nil {
+		t.Fatal(err)
+	}
+	if keyring, err = store.GetKeyring(ctx, "payments"); err != nil || keyring.RotationPeriodDays != 90 {
+		t.Fatalf("rotation period was not updated: %+v, %v", keyring, err)
+	}
+	if err := store.UpdateRotationPeriod(ctx, "missing", 90); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("updating a missing keyring returned %v, want sql.ErrNoRows", err)
+	}
+	if _, err := store.GetKeyring(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("getting a missing keyring returned %v, want sql.ErrNoRows", err)
+	}
+
+	keyrings, err := store.ListKeyrings(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, keyring := range keyrings {
+		if keyring.Name == "billing" || keyring.Name == "payments" {
+			names = append(names, keyring.Name)
+		}
+	}
+	if len(names) != 2 || names[0] != "billing" {
+		t.Fatalf("ListKeyrings returned %v, want billing and payments ordered by name", names)
+	}
+}
+
+func testGetKey(t *testing.T, store repository.KeyStore) {
+	ctx := context.Background()
+	key := createKeyring(t, store, "payments")
+
+	got, err := store.GetKey(ctx, key.KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID == 0 || got.Name != "payments" || got.Version != 1 || !bytes.Equal(got.EncryptedKeyMaterial, key.EncryptedKeyMaterial) {
+		t.Fatalf("GetKey returned %+v", got)
+	}
+	if !got.ExpirationDate.Equal(key.ExpirationDate) {
+		t.Fatalf("expiration date is %v, want %v", got.ExpirationDate, key.ExpirationDate)
+	}
+	if got.Algorithm != key.Algorithm || !bytes.Equal(got.PublicKey, key.PublicKey) {
+		t.Fatalf("algorithm and public key are %q and %q, want %q and %q", got.Algorithm, got.PublicKey, key.Algorithm, key.PublicKey)
+	}
+
+	if _, err := store.GetKey(ctx, uuid.New()); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("getting an unknown key returned %v, want sql.ErrNoRows", err)
+	}
+	if _, err := store.GetCurrentActiveKey(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("getting the primary of a missing key returned %v, want sql.ErrNoRows", err)
+	}
+	versions, err := store.GetAllKeyVersions(ctx, "missing")
+	if err != nil || len(versions) != 0 {
+		t.Fatalf("versions of a missing key are %v, %v, want none", versions, err)
+	}
+}
+
+func testCreateKey(t *testing.T, store repository.KeyStore) {
+	ctx := context.Background()
+	createKeyring(t, store, "payments")
+
+	if err := store.CreateKey(ctx, newKey(t, "missing", 1)); err == nil {
+		t.Fatal("created a key version for a keyring that does not exist")
+	}
+	if err := store.CreateKey(ctx, newKey(t, "payments", 1)); err == nil {
+		t.Fatal("created a duplicate version")
+	}
+	if err := store.CreateKey(ctx, newKey(t, "payments", 2)); err == nil {
+		t.Fatal("created a second ACTIVE version")
+	}
+
+	key := newKey(t, "payments", 2)
+	key.Status = string(model.KeyStatusPending)
+	if err := store.CreateKey(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, store, key, model.KeyStatusPending)
+}
+
+func testRotateKey(t *testing.T, store repository.KeyStore) {
+	ctx := context.Background()
+	v1 := createKeyring(t, store, "payments")
+	other := createKeyring(t, store, "billing")
+
+	v2 := rotate(t, store, "payments", 1)
+	if v2.Version != 2 || v2.Name != "payments" || v2.ID == 0 {
+		t.Fatalf("rotation created %+v, want version 2 of payments", v2)
+	}
+	wantStatus(t, store, v1, model.KeyStatusRotated)
+	wantStatus(t, store, v2, model.KeyStatusActive)
+	wantStatus(t, store, other, model.KeyStatusActive)
+
+	current, err := store.GetCurrentActiveKey(ctx, "payments")
+	if err != nil || current.KeyID != v2.KeyID {
+		t.Fatalf("primary version is %+v, %v, want version 2", current, err)
+	}
+
+	versions, err := store.GetAllKeyVersions(ctx, "payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[1].KeyID != v1.KeyID || versions[2].KeyID != v2.KeyID {
+		t.Fatalf("GetAllKeyVersions returned %v", versions)
+	}
+
+	active, err := store.ListActiveKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range active {
+		if key.KeyID == v1.KeyID {
+			t.Fatal("ListActiveKeys returned a rotated version")
+		}
+	}
+	if len(active) != 2 {
+		t.Fatalf("ListActiveKeys returned %d versions, want 2", len(active))
+	}
+
+	if err := store.RotateKey(ctx, "missing", 0, newKey(t, "missing", 0)); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("rotating a missing key returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testRotateKeyConflict(t *testing.T, store repository.KeyStore) {
+	ctx := context.Background()
+	createKeyring(t, store, "payments")
+	rotate(t, store, "payments", 1)
+
+	err := store.RotateKey(ctx, "payments", 1, newKey(t, "payments", 0))
+	var conflict *repository.VersionConflictError
+	if !errors.As(err, &conflict) || conflict.CurrentVersion != 2 {
+		t.Fatalf("stale rotation returned %v, want a conflict with current version 2", err)
+	}
+}
+
+func testDisableAndEnable(t *testing.T, store repository.KeyStore) {
+	ctx := context.Background()
+	v1 := createKeyring(t, store, "payments")
+	rotate(t, store, "payments", 1)
+
+	disabled, err := store.DisableKeyVersion(ctx, "payments", 1)
+	if err != nil || disabled.Status != string(model.KeyStatusDisabled) {
+		t.Fatalf("DisableKeyVersion returned %+v, %v", disabled, err)
+	}
+	if primary, err := store.GetCurrentActiveKey(ctx, "payments"); err != nil || primary.Version != 2 {
+		t.Fatalf("disabling an older version changed the primary version: %+v, %v", primary, err)
+	}
+
+	enabled, err := store.EnableKeyVersion(ctx, "payments", 1)
+	if err != nil || enabled.Status != string(model.KeyStatusRotated) {
+		t.Fatalf("enabling an older version returned %+v, %v, want ROTATED", enabled, err)
+	}
+	wantStatus(t, store, v1, model.KeyStatusRotated)
+
+	if _, err := store.DisableKeyVersion(ctx, "payments", 9); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("disabling a missing version returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testScheduledDeletion(t *testing.T, store repository.KeyStore) {
+	ctx := context.Background()
+	v1 := createKeyring(t, store, "payments")
+	rotate(t, store, "payments", 1)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	deletionDate := now.Add(24 * time.Hour)
+	scheduled, err := store.ScheduleKeyVersionDeletion(ctx, "payments", 1, deletionDate)
+	if err != nil || scheduled.DeletionDate == nil || !scheduled.DeletionDate.Equal(deletionDate) {
+		t.Fatalf("ScheduleKeyVersionDeletion returned %+v, %v", scheduled, err)
+	}
+
+	if _, err := store.DestroyKeyVersion(ctx, "payments", 1, now); !errors.Is(err, repository.ErrDeletionPending) {
+		t.Fatalf("destroying before the deletion date returned %v, want ErrDeletionPending", err)
+	}
+	due, err := store.ListDueDeletions(ctx, now)
+	if err != nil || len(due) != 0 {
+		t.Fatalf("versions due before the deletion date: %v, %v", due, err)
+	}
+
+	cancelled, err := store.CancelKeyVersionDeletion(ctx, "payments", 1)
+	if err != nil || cancelled.Status != string(model.KeyStatusDisabled) || cancelled.DeletionDate != nil {
+		t.Fatalf("CancelKeyVersionDeletion returned %+v, %v", cancelled, err)
+	}
+	if _, err := store.ScheduleKeyVersionDeletion(ctx, "payments", 1, deletionDate); err != nil {
+		t.Fatal(err)
+	}
+
+	later := deletionDate.Add(time.Minute)
+	due, err = store.ListDueDeletions(ctx, later)
+	if err != nil || len(due) != 1 || due[0].KeyID != v1.KeyID {
+		t.Fatalf("versions due after the deletion date: %v, %v", due, err)
+	}
+
+	destroyed, err := store.DestroyKeyVersion(ctx, "payments", 1, later)
+	if err != nil || destroyed.Status != string(model.KeyStatusDestroyed) {
+		t.Fatalf("DestroyKeyVersion returned %+v, %v", destroyed, err)
+	}
+	got, err := store.GetKey(ctx, v1.KeyID)
+	if err != nil || len(got.EncryptedKeyMaterial) != 0 {
+		t.Fatalf("a destroyed version still has key material: %+v, %v", got, err)
+	}
+}
+
+func testInvalidTransitions(t *testing.T, store repository.KeyStore) {
+	ctx := context.Background()
+	key := createKeyring(t, store, "payments")
+
+	if _, err := store.EnableKeyVersion(ctx, "payments", 1); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Fatalf("enabling an ACTIVE version returned %v, want ErrInvalidTransition", err)
+	}
+	if _, err := store.CancelKeyVersionDeletion(ctx, "payments", 1); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Fatalf("cancelling deletion of an ACTIVE version returned %v, want ErrInvalidTransition", err)
+	}
+	if _, err := store.DestroyKeyVersion(ctx, "payments", 1, time.Now()); !errors.Is(err, model.ErrInvalidTransition) {
+		t.Fatalf("destroying an ACTIVE version returned %v, want ErrInvalidTransition", err)
+	}
+	// The primary version must be rotated away first, a key always keeps one.
+	if _, err := store.DisableKeyVersion(ctx, "payments", 1); !errors.Is(err, model.ErrPrimaryVersion) {
+		t.Fatalf("disabling the ACTIVE version returned %v, want ErrPrimaryVersion", err)
+	}
+	if _, err := store.ScheduleKeyVersionDeletion(ctx, "payments", 1, time.Now().Add(time.Hour)); !errors.Is(err, model.ErrPrimaryVersion) {
+		t.Fatalf("scheduling the deletion of the ACTIVE version returned %v, want ErrPrimaryVersion", err)
+	}
+	wantStatus(t, store, key, model.KeyStatusActive)
+}
+
+func testSealConfig(t *testing.T, store repository.KeyStore) {
+	ctx := context.Background()
+	if _, err := store.GetSealConfig(ctx); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetSealConfig on an empty store returned %v, want sql.ErrNoRows", err)
+	}
+
+	cfg := &model.SealConfig{
+		SecretShares:    5,
+		SecretThreshold: 3,
+		RootKeyCheck:    []byte("check"),
+		CreationDate:    time.Now().UTC().Truncate(time.Second),
+	}
+	if err := store.CreateSealConfig(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.GetSealConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SecretShares != 5 || got.SecretThreshold != 3 || !bytes.Equal(got.RootKeyCheck, cfg.RootKeyCheck) {
+		t.Fatalf("GetSealConfig returned %+v", got)
+	}
+
+	if err := store.CreateSealConfig(ctx, cfg); !errors.Is(err, repository.ErrSealConfigExists) {
+		t.Fatalf("creating a second seal config returned %v, want ErrSealConfigExists", err)
+	}
+}
+
+func testAPIClients(t *testing.T, store repository.KeyStore) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	client := &model.APIClient{
+		ID:           uuid.New(),
+		Name:         "etl",
+		Scopes:       []model.Scope{model.ScopeCryptoEncrypt, model.ScopeCryptoDecrypt},
+		SecretHash:   "hash",
+		CreationDate: now,
+	}
+	if err := store.CreateAPIClient(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	other := &model.APIClient{ID: uuid.New(), Name: "admin", Scopes: []model.Scope{model.ScopeKeysAdmin}, SecretHash: "other", CreationDate: now.Add(time.Second)}
+	if err := store.CreateAPIClient(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetAPIClient(ctx, client.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "etl" || got.SecretHash != "hash" || len(got.Scopes) != 2 || got.Scopes[1] != model.ScopeCryptoDecrypt || got.Revoked() {
+		t.Fatalf("GetAPIClient returned %+v", got)
+	}
+	if _, err := store.GetAPIClient(ctx, uuid.New()); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("getting a missing client returned %v, want sql.ErrNoRows", err)
+	}
+
+	revoked, err := store.RevokeAPIClient(ctx, client.ID, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked.Revoked() || !revoked.RevocationDate.Equal(now.Add(time.Minute)) {
+		t.Fatalf("RevokeAPIClient returned %+v", revoked)
+	}
+	// Revoking again keeps the first revocation date.
+	if revoked, err = store.RevokeAPIClient(ctx, client.ID, now.Add(time.Hour)); err != nil || !revoked.RevocationDate.Equal(now.Add(time.Minute)) {
+		t.Fatalf("revoking twice returned %+v, %v", revoked, err)
+	}
+	if _, err := store.RevokeAPIClient(ctx, uuid.New(), now); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("revoking a missing client returned %v, want sql.ErrNoRows", err)
+	}
+
+	clients, err := store.ListAPIClients(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 || clients[0].ID != client.ID || !clients[0].Revoked() || clients[1].ID != other.ID || clients[1].Revoked() {
+		t.Fatalf("ListAPIClients returned %+v", clients)
+	}
+
+	const identity = "spiffe://example.org/billing"
+	if _, err := store.GetAPIClientByIdentity(ctx, identity); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("getting an unbound identity returned %v, want sql.ErrNoRows", err)
+	}
+	if _, err := store.GetAPIClientByIdentity(ctx, ""); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("getting the empty identity returned %v, want sql.ErrNoRows", err)
+	}
+	billing := &model.APIClient{ID: uuid.New(), Name: "billing", Scopes: []model.Scope{model.ScopeCryptoEncrypt}, CertificateIdentity: identity, CreationDate: now}
+	if err := store.CreateAPIClient(ctx, billing); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.GetAPIClientByIdentity(ctx, identity); err != nil || got.ID != billing.ID || got.CertificateIdentity != identity {
+		t.Fatalf("GetAPIClientByIdentity returned %+v, %v", got, err)
+	}
+	duplicate := &model.APIClient{ID: uuid.New(), Name: "billing", Scopes: []model.Scope{model.ScopeKeysAdmin}, CertificateIdentity: identity, CreationDate: now}
+	if err := store.CreateAPIClient(ctx, duplicate); !errors.Is(err, repository.ErrIdentityTaken) {
+		t.Fatalf("binding a taken identity returned %v, want ErrIdentityTaken", err)
+	}
+	if _, err := store.RevokeAPIClient(ctx, billing.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetAPIClientByIdentity(ctx, identity); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("getting a revoked identity returned %v, want sql.ErrNoRows", err)
+	}
+	// Revoking frees the identity for a new client.
+	if err := store.CreateAPIClient(ctx, duplicate); err != nil {
+		t.Fatalf("binding the identity of a revoked client: %v", err)
+	}
+	if got, err := store.GetAPIClientByIdentity(ctx, identity); err != nil || got.ID != duplicate.ID {
+		t.Fatalf("GetAPIClientByIdentity returned %+v, %v", got, err)
+	}
+}
+
+// testIsolation checks that callers cannot change stored keys by editing returned values.
+func testIsolation(t *testing.T, store repository.KeyStore) {
+	ctx := context.Background()
+	key := createKeyring(t, store, "payments")
+
+	got, err := store.GetKey(ctx, key.KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Status = string(model.KeyStatusDestroyed)
+	got.EncryptedKeyMaterial[0] ^= 0xff
+
+	wantStatus(t, store, key, model.KeyStatusActive)
+	got, err = store.GetKey(ctx, key.KeyID)
+	if err != nil || !bytes.Equal(got.EncryptedKeyMaterial, key.EncryptedKeyMaterial) {
+		t.Fatalf("stored key material changed through a returned value: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Package rotation rotates keys in the background according to their rotation period,
+// and before their primary version expires. It also destroys versions whose scheduled
+// deletion date has passed.
+package rotation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/internal/seal"
+	"github.com/valu/encrpytion/pkg/crypto"
+)
+
+const (
+	// checkInterval is how often keys are checked for due rotations.
+	checkInterval = time.Minute
+	// expiryMargin rotates a primary version this long before it expires, whatever the rotation period.
+	expiryMargin = 7 * 24 * time.Hour
+)
+
+type Scheduler struct {
+	db      repository.KeyStore
+	barrier *seal.Barrier
+	log     *zerolog.Logger
+}
+
+func NewScheduler(db repository.KeyStore, barrier *seal.Barrier, log *zerolog.Logger) *Scheduler {
+	return &Scheduler{db: db, barrier: barrier, log: log}
+}
+
+// Run checks every key once per interval until ctx is cancelled. Nothing is rotated
+// while the keystore is sealed, overdue keys are rotated right after the next unseal.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if s.barrier.Sealed() {
+			continue
+		}
+		if err := s.rotateDueKeys(ctx); err != nil {
+			s.log.Error().Err(err).Msg("Scheduled key rotation failed")
+		}
+		if err := s.destroyDueVersions(ctx); err != nil {
+			s.log.Error().Err(err).Msg("Scheduled key destruction failed")
+		}
+	}
+}
+
+func (s *Scheduler) rotateDueKeys(ctx context.Context) error {
+	keyrings, err := s.db.ListKeyrings(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, keyring := range keyrings {
+		current, err := s.db.GetCurrentActiveKey(ctx, keyring.Name)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		reason := rotationReason(keyring, current, now)
+		if reason == "" {
+			continue
+		}
+		if err := s.rotate(ctx, current, reason); err != nil {
+			s.log.Error().Err(err).Str("key_name", keyring.Name).Int("version", current.Version).
+				Msg("Failed to rotate key")
+		}
+	}
+	return nil
+}
+
+// rotationReason returns why the primary version is due for rotation, or "" if it is not.
+// A zero expiration date never expires, like in model.EncryptionKey.Expired.
+func rotationReason(keyring *model.Keyring, current *model.EncryptionKey, now time.Time) string {
+	if !current.ExpirationDate.IsZero() && now.Add(expiryMargin).After(current.ExpirationDate) {
+		return "expiring"
+	}
+	if keyring.RotationPeriodDays > 0 && now.After(current.CreationDate.AddDate(0, 0, keyring.RotationPeriodDays)) {
+		return "rotation_period"
+	}
+	return ""
+}
+
+func (s *Scheduler) rotate(ctx context.Context, current *model.EncryptionKey, reason string) error {
+	rootKey, err := s.barrier.RootKey()
+	if err != nil {
+		return err
+	}
+
+	newKey, err := crypto.NewKeyVersion(current.Name, current.Version+1, current.Algorithm, rootKey)
+	if err != nil {
+		return err
+	}
+	err = s.db.RotateKey(ctx, current.Name, current.Version, newKey)
+	var conflict *repository.VersionConflictError
+	if errors.As(err, &conflict) {
+		// Another replica or a manual rotation got there first.
+		s.log.Debug().Str("key_name", current.Name).Int("version", conflict.CurrentVersion).Msg("Key already rotated")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.log.Info().Str("key_name", current.Name).Int("old_version", current.Version).Int("new_version", newKey.Version).
+		Str("reason", reason).Msg("Key rotated")
+	return nil
+}
+
+// destroyDueVersions erases the material of versions whose pending deletion window is over.
+func (s *Scheduler) destroyDueVersions(ctx context.Context) error {
+	now := time.Now()
+	due, err := s.db.ListDueDeletions(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range due {
+		if _, err := s.db.DestroyKeyVersion(ctx, key.Name, key.Version, now); err != nil {
+			s.log.Error().Err(err).Str("key_name", key.Name).Int("version", key.Version).Msg("Failed to destroy key version")
+			continue
+		}
+		s.log.Info().Str("key_name", key.Name).Int("version", key.Version).Msg("Key version destroyed")
+	}
+	return nil
+}
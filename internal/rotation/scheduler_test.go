@@ -0,0 +1,37 @@
+package rotation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valu/encrpytion/internal/model"
+)
+
+func TestRotationReason(t *testing.T) {
+	now := time.Date(2024, 10, 21, 12, 0, 0, 0, time.UTC)
+	created := now.AddDate(0, 0, -10)
+
+	tests := []struct {
+		name       string
+		periodDays int
+		expiration time.Time
+		want       string
+	}{
+		{"no expiration and no period", 0, time.Time{}, ""},
+		{"no expiration within period", 30, time.Time{}, ""},
+		{"no expiration past period", 5, time.Time{}, "rotation_period"},
+		{"expiring far ahead", 0, now.AddDate(1, 0, 0), ""},
+		{"expiring within margin", 0, now.Add(expiryMargin - time.Hour), "expiring"},
+		{"already expired", 30, now.Add(-time.Hour), "expiring"},
+		{"past period before expiry", 5, now.AddDate(1, 0, 0), "rotation_period"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring := &model.Keyring{Name: "test", RotationPeriodDays: tt.periodDays}
+			current := &model.EncryptionKey{Name: "test", CreationDate: created, ExpirationDate: tt.expiration}
+			if got := rotationReason(keyring, current, now); got != tt.want {
+				t.Errorf("rotationReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+// Package seal keeps the root key out of reach until enough operators unseal the keystore.
+//
+// The root key is never stored. On init it is split into Shamir shares that are handed
+// to operators, and on unseal it is reconstructed in memory from a threshold of them.
+package seal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/model"
+	"github.com/valu/encrpytion/internal/repository"
+	"github.com/valu/encrpytion/pkg/crypto"
+	"github.com/valu/encrpytion/pkg/shamir"
+)
+
+var (
+	ErrSealed             = errors.New("keystore is sealed")
+	ErrNotInitialized     = errors.New("keystore is not initialized")
+	ErrAlreadyInitialized = errors.New("keystore is already initialized")
+	ErrAlreadyUnsealed    = errors.New("keystore is already unsealed")
+	ErrInvalidShares      = errors.New("unseal failed, the submitted key shares do not reconstruct the root key, reset the unseal to start over")
+	ErrTooManyShares      = errors.New("as many key shares as were created are pending, reset the unseal to start over")
+	ErrMalformedShare     = errors.New("key share has an invalid length")
+)
+
+// rootKeyCheckAAD is bound to the root key check value so it cannot be confused with a wrapped key.
+var rootKeyCheckAAD = []byte("root-key-check")
+
+// Status describes the seal state as reported by /v1/sys/seal-status.
+type Status struct {
+	Initialized bool `json:"initialized"`
+	Sealed      bool `json:"sealed"`
+	Threshold   int  `json:"threshold"`
+	Shares      int  `json:"shares"`
+	Progress    int  `json:"progress"`
+}
+
+type Barrier struct {
+	mu            sync.RWMutex
+	db            repository.KeyStore
+	log           *zerolog.Logger
+	rootKey       []byte
+	pendingShares [][]byte
+	// legacyRootKey is the ROOT_KEY of deployments that predate sealing. When set,
+	// Init splits it instead of generating a new root key so existing keys stay readable.
+	legacyRootKey []byte
+}
+
+func NewBarrier(db repository.KeyStore, legacyRootKey []byte, log *zerolog.Logger) *Barrier {
+	return &Barrier{db: db, legacyRootKey: legacyRootKey, log: log}
+}
+
+// RootKey returns a copy of the root key, or ErrSealed while the keystore is sealed.
+// A copy is handed out so that sealing cannot zero the key under an in-flight request.
+func (b *Barrier) RootKey() ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.rootKey == nil {
+		return nil, ErrSealed
+	}
+	return append([]byte(nil), b.rootKey...), nil
+}
+
+func (b *Barrier) Sealed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.rootKey == nil
+}
+
+func (b *Barrier) Status(ctx context.Context) (*Status, error) {
+	cfg, err := b.db.GetSealConfig(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Status{Sealed: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return &Status{
+		Initialized: true,
+		Sealed:      b.rootKey == nil,
+		Threshold:   cfg.SecretThreshold,
+		Shares:      cfg.SecretShares,
+		Progress:    len(b.pendingShares),
+	}, nil
+}
+
+// Init creates the root key, splits it into shares and leaves the keystore sealed.
+// The shares are returned exactly once and are never persisted.
+func (b *Barrier) Init(ctx context.Context, shares, threshold int) ([][]byte, error) {
+	if _, err := b.db.GetSealConfig(ctx); err == nil {
+		return nil, ErrAlreadyInitialized
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	rootKey := b.legacyRootKey
+	if rootKey == nil {
+		rootKey = make([]byte, crypto.RootKeySize)
+		if _, err := io.ReadFull(rand.Reader, rootKey); err != nil {
+			return nil, err
+		}
+	}
+
+	keyShares, err := shamir.Split(rootKey, shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := crypto.WrapKey(rootKey, rootKeyCheckAAD, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := model.SealConfig{
+		SecretShares:    shares,
+		SecretThreshold: threshold,
+		RootKeyCheck:    check,
+		CreationDate:    time.Now(),
+	}
+	err = b.db.CreateSealConfig(ctx, &cfg)
+	if errors.Is(err, repository.ErrSealConfigExists) {
+		// Another replica won the race, its shares are the valid ones.
+		return nil, ErrAlreadyInitialized
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to store seal config: %w", err)
+	}
+	return keyShares, nil
+}
+
+// Unseal records one key share. Once the threshold is reached the root key is
+// reconstructed from every pending share and verified. The shares are only discarded by
+// a successful unseal or by ResetUnseal, a bad share keeps failing the unseal until then.
+func (b *Barrier) Unseal(ctx context.Context, share []byte) (*Status, error) {
+	cfg, err := b.db.GetSealConfig(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotInitialized
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rootKey != nil {
+		return nil, ErrAlreadyUnsealed
+	}
+	if len(share) != crypto.RootKeySize+shamir.ShareOverhead {
+		return nil, ErrMalformedShare
+	}
+	for _, pending := range b.pendingShares {
+		if subtle.ConstantTimeCompare(pending, share) == 1 {
+			return b.statusLocked(cfg), nil
+		}
+	}
+	if len(b.pendingShares) >= cfg.SecretShares {
+		return nil, ErrTooManyShares
+	}
+	// Pending shares are zeroed on reset, the caller keeps its own copy.
+	b.pendingShares = append(b.pendingShares, bytes.Clone(share))
+	if len(b.pendingShares) < cfg.SecretThreshold {
+		return b.statusLocked(cfg), nil
+	}
+
+	rootKey, err := shamir.Combine(b.pendingShares)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidShares, err)
+	}
+	if _, err := crypto.UnwrapKey(rootKey, rootKeyCheckAAD, cfg.RootKeyCheck); err != nil {
+		clear(rootKey)
+		return nil, ErrInvalidShares
+	}
+	b.resetLocked()
+	b.rootKey = rootKey
+	b.legacyRootKey = nil
+	b.log.Info().Msg("Keystore unsealed")
+
+	if err := b.wrapLegacyKeys(ctx, rootKey); err != nil {
+		b.log.Error().Err(err).Msg("Failed to wrap legacy key material")
+	}
+
+	return b.statusLocked(cfg), nil
+}
+
+// ResetUnseal discards any shares submitted so far.
+func (b *Barrier) ResetUnseal() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.resetLocked()
+}
+
+// Seal drops the root key from memory. Every key and crypto operation fails until the next unseal.
+func (b *Barrier) Seal() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.rootKey {
+		b.rootKey[i] = 0
+	}
+	b.rootKey = nil
+	b.resetLocked()
+	b.log.Info().Msg("Keystore sealed")
+}
+
+func (b *Barrier) statusLocked(cfg *model.SealConfig) *Status {
+	return &Status{
+		Initialized: true,
+		Sealed:      b.rootKey == nil,
+		Threshold:   cfg.SecretThreshold,
+		Shares:      cfg.SecretShares,
+		Progress:    len(b.pendingShares),
+	}
+}
+
+func (b *Barrier) resetLocked() {
+	for _, share := range b.pendingShares {
+		for i := range share {
+			share[i] = 0
+		}
+	}
+	b.pendingShares = nil
+}
+
+// wrapLegacyKeys wraps key material written before root key wrapping existed.
+func (b *Barrier) wrapLegacyKeys(ctx context.Context, rootKey []byte) error {
+	rewrapped, err := b.db.RewrapLegacyKeys(ctx, func(key *model.EncryptionKey) ([]byte, error) {
+		return crypto.WrapKey(rootKey, key.KeyID[:], key.EncryptedKeyMaterial)
+	})
+	if err != nil {
+		return err
+	}
+	if rewrapped > 0 {
+		b.log.Info().Int("keys", rewrapped).Msg("Wrapped legacy key material under the root key")
+	}
+	return nil
+}
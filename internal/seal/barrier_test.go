@@ -0,0 +1,96 @@
+package seal
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/valu/encrpytion/internal/repository/memory"
+)
+
+func newTestBarrier(t *testing.T, shares, threshold int) (*Barrier, [][]byte) {
+	t.Helper()
+	log := zerolog.Nop()
+	barrier := NewBarrier(memory.New(), nil, &log)
+	keyShares, err := barrier.Init(context.Background(), shares, threshold)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return barrier, keyShares
+}
+
+func TestUnseal(t *testing.T) {
+	ctx := context.Background()
+	barrier, shares := newTestBarrier(t, 3, 2)
+
+	status, err := barrier.Unseal(ctx, shares[2])
+	if err != nil {
+		t.Fatalf("Unseal: %v", err)
+	}
+	if !status.Sealed || status.Progress != 1 {
+		t.Fatalf("status after one share = %+v, want sealed with progress 1", status)
+	}
+	// Submitting the same share again does not count twice.
+	if status, err = barrier.Unseal(ctx, shares[2]); err != nil || status.Progress != 1 {
+		t.Fatalf("Unseal of a repeated share = %+v, %v, want progress 1", status, err)
+	}
+
+	status, err = barrier.Unseal(ctx, shares[0])
+	if err != nil {
+		t.Fatalf("Unseal: %v", err)
+	}
+	if status.Sealed || barrier.Sealed() {
+		t.Fatal("keystore is still sealed after the threshold was reached")
+	}
+	if _, err := barrier.RootKey(); err != nil {
+		t.Fatalf("RootKey: %v", err)
+	}
+	if _, err := barrier.Unseal(ctx, shares[1]); !errors.Is(err, ErrAlreadyUnsealed) {
+		t.Fatalf("Unseal after unsealing = %v, want ErrAlreadyUnsealed", err)
+	}
+}
+
+func TestBadShareKeepsProgress(t *testing.T) {
+	ctx := context.Background()
+	barrier, shares := newTestBarrier(t, 3, 2)
+
+	bogus := make([]byte, len(shares[0]))
+	if _, err := rand.Read(bogus); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := barrier.Unseal(ctx, shares[0]); err != nil {
+		t.Fatalf("Unseal: %v", err)
+	}
+	if _, err := barrier.Unseal(ctx, bogus); !errors.Is(err, ErrInvalidShares) {
+		t.Fatalf("Unseal of a bogus share = %v, want ErrInvalidShares", err)
+	}
+
+	// The bogus share stays pending, so the good ones cannot finish the unseal, but they
+	// are not discarded either.
+	if _, err := barrier.Unseal(ctx, shares[1]); !errors.Is(err, ErrInvalidShares) {
+		t.Fatalf("Unseal with a bogus share pending = %v, want ErrInvalidShares", err)
+	}
+	status, err := barrier.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if !status.Sealed || status.Progress != 3 {
+		t.Fatalf("status after a failed unseal = %+v, want sealed with progress 3", status)
+	}
+	if _, err := barrier.Unseal(ctx, shares[2]); !errors.Is(err, ErrTooManyShares) {
+		t.Fatalf("Unseal past the share count = %v, want ErrTooManyShares", err)
+	}
+
+	barrier.ResetUnseal()
+	if _, err := barrier.Unseal(ctx, shares[1]); err != nil {
+		t.Fatalf("Unseal after reset: %v", err)
+	}
+	if _, err := barrier.Unseal(ctx, shares[2]); err != nil {
+		t.Fatalf("Unseal after reset: %v", err)
+	}
+	if barrier.Sealed() {
+		t.Fatal("keystore is still sealed after a reset and two good shares")
+	}
+}
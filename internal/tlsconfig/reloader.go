@@ -0,0 +1,173 @@
+// Package tlsconfig serves TLS with a certificate, and optionally a client CA bundle for
+// mTLS, that are reloaded from disk when the files change, so renewed certificates are
+// picked up without a restart.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// checkInterval is how often the files are checked for changes.
+const checkInterval = 30 * time.Second
+
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mTLS: client certificates are verified against the PEM bundle
+	// it holds. ClientAuth chooses whether clients must present one.
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+}
+
+// Reloader holds the certificate and client CAs loaded from the files in Options.
+type Reloader struct {
+	opts    Options
+	log     *zerolog.Logger
+	current atomic.Pointer[loaded]
+	// stamps are the sizes and modification times of the files when they were loaded.
+	stamps []fileStamp
+}
+
+type loaded struct {
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// NewReloader loads the files once and fails when they cannot be used.
+func NewReloader(opts Options, log *zerolog.Logger) (*Reloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("both a certificate and a key file are required")
+	}
+	r := &Reloader{opts: opts, log: log}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a server config that always uses the latest loaded files.
+func (r *Reloader) TLSConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+	}
+	if r.opts.ClientCAFile != "" {
+		// The client CAs are fixed per config, so every handshake gets a config holding
+		// the current ones.
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				NextProtos:     []string{"h2", "http/1.1"},
+				GetCertificate: r.getCertificate,
+				ClientAuth:     r.opts.ClientAuth,
+				ClientCAs:      r.current.Load().clientCAs,
+			}, nil
+		}
+	}
+	return config
+}
+
+func (r *Reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current.Load().cert, nil
+}
+
+// Run reloads the files whenever one of them changes, until ctx is cancelled. A reload
+// that fails is logged and the previous files stay in use, a certificate and key that
+// are replaced one after the other are picked up once both are in place.
+func (r *Reloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stamps, err := r.statFiles()
+		if err != nil {
+			r.log.Error().Err(err).Msg("Failed to check TLS files for changes")
+			continue
+		}
+		if !changed(stamps, r.stamps) {
+			continue
+		}
+		if err := r.reload(); err != nil {
+			r.log.Error().Err(err).Msg("Failed to reload TLS files, keeping the previous ones")
+		}
+	}
+}
+
+func (r *Reloader) reload() error {
+	// Stat first: a file replaced while it is read is loaded again on the next check.
+	stamps, err := r.statFiles()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	next := &loaded{cert: &cert}
+
+	if r.opts.ClientCAFile != "" {
+		bundle, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		next.clientCAs = x509.NewCertPool()
+		if !next.clientCAs.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("client CA file %s holds no PEM certificates", r.opts.ClientCAFile)
+		}
+	}
+
+	r.current.Store(next)
+	r.stamps = stamps
+	r.log.Info().Str("subject", cert.Leaf.Subject.String()).Time("not_after", cert.Leaf.NotAfter).
+		Msg("Loaded TLS certificate")
+	return nil
+}
+
+func (r *Reloader) statFiles() ([]fileStamp, error) {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	stamps := make([]fileStamp, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		stamps[i] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+	}
+	return stamps, nil
+}
+
+func changed(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return true
+	}
+	for i := range a {
+		if a[i].size != b[i].size || !a[i].modTime.Equal(b[i].modTime) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Rows that exist before this migration hold raw key material. They are marked as
+-- not wrapped here and re-wrapped under the root key by the API on startup, since
+-- the root key is never available to the migration tool.
+ALTER TABLE encryption_keys ADD COLUMN material_wrapped BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE encryption_keys ALTER COLUMN material_wrapped SET DEFAULT TRUE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE encryption_keys DROP COLUMN IF EXISTS material_wrapped;
+-- +goose StatementEnd
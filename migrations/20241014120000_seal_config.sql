@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS seal_config (
+    id INTEGER PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    secret_shares INTEGER NOT NULL,
+    secret_threshold INTEGER NOT NULL,
+    root_key_check BYTEA NOT NULL,
+    creation_date TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS seal_config;
+-- +goose StatementEnd
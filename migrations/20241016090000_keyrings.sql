@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS keyrings (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    creation_date TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+-- Keys created before keyrings existed all shared one global version history,
+-- they are moved into a keyring called "default".
+INSERT INTO keyrings (name)
+SELECT 'default' WHERE EXISTS (SELECT 1 FROM encryption_keys);
+ALTER TABLE encryption_keys ADD COLUMN key_name VARCHAR(64) NOT NULL DEFAULT 'default' REFERENCES keyrings (name);
+ALTER TABLE encryption_keys ALTER COLUMN key_name DROP DEFAULT;
+CREATE INDEX IF NOT EXISTS encryption_keys_key_name_version_idx ON encryption_keys (key_name, version);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS encryption_keys_key_name_version_idx;
+ALTER TABLE encryption_keys DROP COLUMN IF EXISTS key_name;
+DROP TABLE IF EXISTS keyrings;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE IF NOT EXISTS rewrap_jobs (
+    id UUID PRIMARY KEY,
+    key_name VARCHAR(64) NOT NULL REFERENCES keyrings (name),
+    source_type VARCHAR(16) NOT NULL CHECK (source_type IN ('postgres')),
+    source_table TEXT NOT NULL,
+    id_column TEXT NOT NULL,
+    ciphertext_column TEXT NOT NULL,
+    encryption_context JSONB NOT NULL DEFAULT '{}',
+    below_version INTEGER NOT NULL,
+    batch_size INTEGER NOT NULL,
+    status VARCHAR(16) NOT NULL CHECK (status IN ('PENDING', 'RUNNING', 'COMPLETED', 'FAILED', 'CANCELLED')),
+    checkpoint TEXT,
+    processed BIGINT NOT NULL DEFAULT 0,
+    rewrapped BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0,
+    error TEXT,
+    -- owner and lease_expires_at let a replica that dies mid-job hand it over to another one.
+    owner UUID,
+    lease_expires_at TIMESTAMP WITH TIME ZONE,
+    creation_date TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_date TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS rewrap_jobs_status_idx ON rewrap_jobs (status);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS rewrap_jobs;
+-- +goose StatementEnd
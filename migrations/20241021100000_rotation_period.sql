@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- 0 disables periodic rotation, the primary version is then only rotated shortly before it expires.
+ALTER TABLE keyrings ADD COLUMN rotation_period_days INTEGER NOT NULL DEFAULT 0 CHECK (rotation_period_days >= 0);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE keyrings DROP COLUMN IF EXISTS rotation_period_days;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+ALTER TABLE encryption_keys DROP CONSTRAINT IF EXISTS encryption_keys_status_check;
+ALTER TABLE encryption_keys ALTER COLUMN status TYPE VARCHAR(32);
+-- INACTIVE was never written by the service, any such row is treated as disabled.
+UPDATE encryption_keys SET status = 'DISABLED' WHERE status = 'INACTIVE';
+ALTER TABLE encryption_keys ADD CONSTRAINT encryption_keys_status_check
+    CHECK (status IN ('PENDING', 'ACTIVE', 'ROTATED', 'DISABLED', 'SCHEDULED_FOR_DELETION', 'DESTROYED'));
+ALTER TABLE encryption_keys ADD COLUMN deletion_date TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE encryption_keys DROP COLUMN IF EXISTS deletion_date;
+ALTER TABLE encryption_keys DROP CONSTRAINT IF EXISTS encryption_keys_status_check;
+UPDATE encryption_keys SET status = 'INACTIVE' WHERE status NOT IN ('ACTIVE', 'ROTATED');
+ALTER TABLE encryption_keys ALTER COLUMN status TYPE VARCHAR(10);
+ALTER TABLE encryption_keys ADD CONSTRAINT encryption_keys_status_check
+    CHECK (status IN ('ACTIVE', 'INACTIVE', 'ROTATED'));
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Concurrent rotations could write the same version twice. Colliding rows are renumbered
+-- past the highest version of their key, the oldest row keeps its number.
+WITH ranked AS (
+    SELECT id, key_name, ROW_NUMBER() OVER (PARTITION BY key_name, version ORDER BY id) AS dup
+    FROM encryption_keys
+), maxes AS (
+    SELECT key_name, MAX(version) AS max_version FROM encryption_keys GROUP BY key_name
+), renumbered AS (
+    SELECT r.id, m.max_version + ROW_NUMBER() OVER (PARTITION BY r.key_name ORDER BY r.id) AS new_version
+    FROM ranked r JOIN maxes m USING (key_name)
+    WHERE r.dup > 1
+)
+UPDATE encryption_keys e SET version = n.new_version FROM renumbered n WHERE e.id = n.id;
+-- Only the newest ACTIVE version of a key stays primary.
+UPDATE encryption_keys e SET status = 'ROTATED'
+WHERE e.status = 'ACTIVE' AND EXISTS (
+    SELECT 1 FROM encryption_keys n
+    WHERE n.key_name = e.key_name AND n.status = 'ACTIVE' AND n.version > e.version
+);
+DROP INDEX IF EXISTS encryption_keys_key_name_version_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS encryption_keys_key_name_version_key ON encryption_keys (key_name, version);
+CREATE UNIQUE INDEX IF NOT EXISTS encryption_keys_one_active_key ON encryption_keys (key_name) WHERE status = 'ACTIVE';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS encryption_keys_one_active_key;
+DROP INDEX IF EXISTS encryption_keys_key_name_version_key;
+CREATE INDEX IF NOT EXISTS encryption_keys_key_name_version_idx ON encryption_keys (key_name, version);
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Every key created so far is AES-256-GCM. The names are validated by the cipher-suite
+-- registry in pkg/crypto rather than a CHECK, so adding a suite needs no migration.
+ALTER TABLE encryption_keys ADD COLUMN IF NOT EXISTS algorithm VARCHAR(32) NOT NULL DEFAULT 'AES256_GCM';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE encryption_keys DROP COLUMN IF EXISTS algorithm;
+-- +goose StatementEnd
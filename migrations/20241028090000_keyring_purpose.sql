@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Every keyring so far encrypts with random nonces. Like the algorithm names, purposes
+-- are validated in pkg/crypto rather than by a CHECK.
+ALTER TABLE keyrings ADD COLUMN IF NOT EXISTS purpose VARCHAR(32) NOT NULL DEFAULT 'encrypt';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE keyrings DROP COLUMN IF EXISTS purpose;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Asymmetric versions keep their private half wrapped in encrypted_key_material and the
+-- public half here, so it can be exported without unwrapping anything.
+ALTER TABLE encryption_keys ADD COLUMN IF NOT EXISTS public_key BYTEA;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE encryption_keys DROP COLUMN IF EXISTS public_key;
+-- +goose StatementEnd
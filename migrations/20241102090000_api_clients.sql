@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- API clients authenticate with bearer tokens. Only an argon2id hash of the secret is
+-- stored; scopes are space separated.
+CREATE TABLE IF NOT EXISTS api_clients (
+    id UUID PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    scopes TEXT NOT NULL,
+    secret_hash TEXT NOT NULL,
+    creation_date TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    revocation_date TIMESTAMP WITH TIME ZONE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS api_clients;
+-- +goose StatementEnd
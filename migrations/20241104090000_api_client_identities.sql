@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Clients bound to a certificate identity authenticate with mTLS client certificates
+-- instead of a token.
+ALTER TABLE api_clients ADD COLUMN IF NOT EXISTS certificate_identity TEXT;
+-- An identity authenticates as exactly one client until that client is revoked.
+CREATE UNIQUE INDEX IF NOT EXISTS api_clients_certificate_identity_key ON api_clients (certificate_identity)
+    WHERE certificate_identity IS NOT NULL AND revocation_date IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS api_clients_certificate_identity_key;
+ALTER TABLE api_clients DROP COLUMN IF EXISTS certificate_identity;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+// Package migrations embeds the Postgres schema migrations so the binary can apply them
+// itself, see NewProvider.
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+//go:embed *.sql
+var FS embed.FS
+
+// NewProvider returns a goose provider for the embedded migrations. Every operation holds
+// a Postgres advisory lock for its whole run, so replicas that start together apply
+// each migration exactly once and the others wait until the schema is current.
+func NewProvider(db *sql.DB) (*goose.Provider, error) {
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, err
+	}
+	return goose.NewProvider(goose.DialectPostgres, db, FS, goose.WithSessionLocker(locker))
+}
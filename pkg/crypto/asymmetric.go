@@ -0,0 +1,70 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// Ciphertexts of asymmetric_decrypt keys are made offline by partners with standard
+// tooling, so they are raw HPKE or RSA-OAEP output rather than envelopes, and callers
+// name the key version on decryption. The canonical encryption context is bound as the
+// HPKE AAD or the OAEP label, the HPKE info is empty.
+
+// EncryptAsymmetric encrypts message to the stored public half of an asymmetric_decrypt
+// key version. It needs no root key, it is what partners do with the exported key.
+func EncryptAsymmetric(message []byte, encryptionContext EncryptionContext, key *model.EncryptionKey) ([]byte, error) {
+	if len(key.PublicKey) == 0 {
+		return nil, ErrNoPublicKey
+	}
+	suite, err := SuiteByName(key.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if suite.Purpose != model.KeyPurposeAsymmetricDecrypt {
+		return nil, fmt.Errorf("%w: key %q has purpose %s", ErrKeyPurpose, key.Name, suite.Purpose)
+	}
+	pub, err := x509.ParsePKIXPublicKey(key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	aad := encryptionContext.Canonical()
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, message, aad)
+	case *ecdh.PublicKey:
+		return hpkeSeal(pub, nil, aad, message)
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// DecryptAsymmetric decrypts a ciphertext made with the public half of an
+// asymmetric_decrypt key version. It fails unless the encryption context matches the
+// one given on encryption.
+func DecryptAsymmetric(ciphertext []byte, encryptionContext EncryptionContext, masterKey *MasterKey) ([]byte, error) {
+	if err := masterKey.requirePurpose(model.KeyPurposeAsymmetricDecrypt); err != nil {
+		return nil, err
+	}
+
+	aad := encryptionContext.Canonical()
+	switch private := masterKey.private.(type) {
+	case *rsa.PrivateKey:
+		message, err := rsa.DecryptOAEP(sha256.New(), nil, private, ciphertext, aad)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt message: %w", err)
+		}
+		return message, nil
+	case *ecdh.PrivateKey:
+		return hpkeOpen(private, nil, aad, ciphertext)
+	default:
+		return nil, errors.New("key cannot decrypt")
+	}
+}
@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAsymmetricRoundTrip(t *testing.T) {
+	encryptionContext := EncryptionContext{"partner": "acme"}
+	for _, algorithm := range []string{AlgorithmNameRSAOAEP2048SHA256, AlgorithmNameHPKEX25519} {
+		t.Run(algorithm, func(t *testing.T) {
+			masterKey := newTestMasterKey(t, algorithm)
+
+			// Partners only hold the stored public half.
+			ciphertext, err := EncryptAsymmetric([]byte("card number"), encryptionContext, masterKey.EncryptionKey)
+			if err != nil {
+				t.Fatalf("EncryptAsymmetric: %v", err)
+			}
+			message, err := DecryptAsymmetric(ciphertext, encryptionContext, masterKey)
+			if err != nil {
+				t.Fatalf("DecryptAsymmetric: %v", err)
+			}
+			if string(message) != "card number" {
+				t.Fatalf("DecryptAsymmetric returned %q", message)
+			}
+
+			if _, err := DecryptAsymmetric(ciphertext, EncryptionContext{"partner": "other"}, masterKey); err == nil {
+				t.Fatal("DecryptAsymmetric succeeded with a different encryption context")
+			}
+			if _, err := DecryptAsymmetric(ciphertext, nil, masterKey); err == nil {
+				t.Fatal("DecryptAsymmetric succeeded without the encryption context")
+			}
+			if _, err := DecryptAsymmetric(ciphertext, encryptionContext, newTestMasterKey(t, algorithm)); err == nil {
+				t.Fatal("DecryptAsymmetric succeeded with another key")
+			}
+		})
+	}
+}
+
+func TestAsymmetricRequiresDecryptKey(t *testing.T) {
+	masterKey := newTestMasterKey(t, AlgorithmNameEd25519)
+	if _, err := EncryptAsymmetric([]byte("message"), nil, masterKey.EncryptionKey); !errors.Is(err, ErrKeyPurpose) {
+		t.Fatalf("EncryptAsymmetric to a sign key returned %v, want ErrKeyPurpose", err)
+	}
+	if _, err := DecryptAsymmetric([]byte("ciphertext"), nil, masterKey); !errors.Is(err, ErrKeyPurpose) {
+		t.Fatalf("DecryptAsymmetric with a sign key returned %v, want ErrKeyPurpose", err)
+	}
+	if _, err := EncryptAsymmetric([]byte("message"), nil, newTestMasterKey(t, DefaultAlgorithm).EncryptionKey); !errors.Is(err, ErrNoPublicKey) {
+		t.Fatalf("EncryptAsymmetric to a symmetric key returned %v, want ErrNoPublicKey", err)
+	}
+}
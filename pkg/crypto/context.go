@@ -0,0 +1,37 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// EncryptionContext is a set of non-secret key/value pairs bound to a ciphertext as
+// additional authenticated data. Decryption only succeeds with the exact same context.
+type EncryptionContext map[string]string
+
+// Canonical serializes the context independent of map ordering: pairs are sorted by key
+// and every key and value is prefixed with its length as a 4-byte big endian integer.
+// An empty context serializes to nil, which is the same AAD as no context at all.
+func (c EncryptionContext) Canonical() []byte {
+	if len(c) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(c))
+	size := 4
+	for k, v := range c {
+		keys = append(keys, k)
+		size += 8 + len(k) + len(v)
+	}
+	sort.Strings(keys)
+
+	buf := make([]byte, 0, size)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(keys)))
+	for _, k := range keys {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(k)))
+		buf = append(buf, k...)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(c[k])))
+		buf = append(buf, c[k]...)
+	}
+	return buf
+}
@@ -4,73 +4,129 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 
 	"github.com/valu/encrpytion/internal/model"
 )
 
-func EncryptMessage(message []byte, masterKey *model.EncryptionKey) ([]byte, []byte, error) {
-	// This creates a new 32-byte (256-bit) data key using a cryptographically secure random number generator. again be aware of package it should be crypto/rand not math/rand
-	dataKey := make([]byte, 32)
-	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
-		return nil, nil, err
+// EncryptMessage encrypts message with a fresh data key, wraps the data key under the
+// master key and returns both as a self-describing envelope. The encryption context is
+// bound as AAD to both the data key wrap and the payload.
+func EncryptMessage(message []byte, encryptionContext EncryptionContext, masterKey *MasterKey) ([]byte, error) {
+	if err := masterKey.requirePurpose(model.KeyPurposeEncrypt); err != nil {
+		return nil, err
 	}
-
-	// This sets up AES encryption using the data key.
-	block, err := aes.NewCipher(dataKey)
+	dataKey, err := newDataKey()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	// GCM (Galois/Counter Mode) is an authenticated encryption mode that provides both confidentiality and integrity.
-	gcm, err := cipher.NewGCM(block)
+	// The key's suite is an authenticated encryption mode that provides both confidentiality and integrity.
+	aead, err := masterKey.suite.New(dataKey)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	// A nonce (number used once) is a random number used once to ensure unique encryptions.
-	nonce := make([]byte, gcm.NonceSize())
+	nonce := make([]byte, aead.NonceSize())
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	aad := encryptionContext.Canonical()
+	ciphertext := aead.Seal(nil, nonce, message, aad)
 
-	// adds version of master key to message
-	// version is 4 bytes long and is the first 4 bytes of the message
-	// version is used to determine which master key to use for decryption
-	// version is also added to the encrypted message to allow for versioning of the encryption algorithm
-	gcm.NonceSize()
-	versionBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(versionBytes, uint32(masterKey.Version))
-	messageWithVersion := append(versionBytes, message...)
+	wrappedDataKey, err := wrapDataKey(dataKey, dataKeyForMessage, aad, masterKey)
+	if err != nil {
+		return nil, err
+	}
 
-	// This encrypts the message (with version) using GCM and prepends the nonce.
-	encryptedMessage := gcm.Seal(nil, nonce, messageWithVersion, nil)
-	encryptedMessageWithNonce := append(nonce, encryptedMessage...)
+	// The key id and version travel in the clear so decryption can look up exactly one master key.
+	envelope := Envelope{
+		Algorithm:      masterKey.suite.ID,
+		KeyID:          masterKey.KeyID,
+		KeyVersion:     uint32(masterKey.Version),
+		WrappedDataKey: wrappedDataKey,
+		Nonce:          nonce,
+		Ciphertext:     ciphertext,
+	}
+	return envelope.MarshalBinary()
+}
 
-	// This sets up AES-GCM encryption using the master key.
-	masterBlock, err := aes.NewCipher(masterKey.EncryptedKeyMaterial)
-	if err != nil {
-		return nil, nil, err
+// EncryptDeterministic encrypts message directly under a deterministic master key. The
+// same message and encryption context always give the same envelope under one key
+// version, so stored ciphertexts can be compared for equality. Rotation starts a new
+// version, equal plaintexts only compare equal once rewrapped to the same version.
+func EncryptDeterministic(message []byte, encryptionContext EncryptionContext, masterKey *MasterKey) ([]byte, error) {
+	if err := masterKey.requirePurpose(model.KeyPurposeDeterministic); err != nil {
+		return nil, err
 	}
-	masterGCM, err := cipher.NewGCM(masterBlock)
+	aead, err := masterKey.suite.New(masterKey.material)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+
+	envelope := Envelope{
+		Algorithm:  masterKey.suite.ID,
+		KeyID:      masterKey.KeyID,
+		KeyVersion: uint32(masterKey.Version),
+		Ciphertext: aead.Seal(nil, nil, message, encryptionContext.Canonical()),
 	}
-	masterNonce := make([]byte, masterGCM.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, masterNonce); err != nil {
-		return nil, nil, err
+	return envelope.MarshalBinary()
+}
+
+// DecryptMessage opens an envelope with the master key it names, randomized and
+// deterministic envelopes alike. It fails unless the encryption context matches the one
+// given on encryption.
+func DecryptMessage(envelope *Envelope, encryptionContext EncryptionContext, masterKey *MasterKey) ([]byte, error) {
+	if masterKey.suite.Purpose == model.KeyPurposeDeterministic {
+		return decryptDeterministic(envelope, encryptionContext, masterKey)
 	}
 
-	// This encrypts the data key using the master key.
-	encryptedDataKey := masterGCM.Seal(masterNonce, masterNonce, dataKey, nil)
+	aad := encryptionContext.Canonical()
+	dataKey, err := unwrapDataKey(envelope, dataKeyForMessage, aad, masterKey)
+	if err != nil {
+		return nil, err
+	}
 
-	return encryptedMessageWithNonce, encryptedDataKey, nil
+	aead, err := masterKey.suite.New(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope.Nonce) != aead.NonceSize() {
+		return nil, errors.New("envelope nonce has an invalid length")
+	}
+	message, err := aead.Open(nil, envelope.Nonce, envelope.Ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	return message, nil
 }
 
-func DecryptMessage(encryptedMessage, encryptedDataKey []byte, keyVersions map[uint32]*model.EncryptionKey) ([]byte, error) {
+func decryptDeterministic(envelope *Envelope, encryptionContext EncryptionContext, masterKey *MasterKey) ([]byte, error) {
+	if err := masterKey.matchEnvelope(envelope); err != nil {
+		return nil, err
+	}
+	if len(envelope.WrappedDataKey) != 0 || len(envelope.Nonce) != 0 {
+		return nil, errors.New("deterministic envelope must not carry a data key or nonce")
+	}
+	aead, err := masterKey.suite.New(masterKey.material)
+	if err != nil {
+		return nil, err
+	}
+	message, err := aead.Open(nil, nil, envelope.Ciphertext, encryptionContext.Canonical())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	return message, nil
+}
+
+// DecryptLegacyMessage decrypts ciphertexts produced before the envelope format, where the
+// message and the wrapped data key were returned separately and the key version was only
+// stored inside the encrypted payload.
+func DecryptLegacyMessage(encryptedMessage, encryptedDataKey []byte, keyVersions map[uint32]*model.EncryptionKey, rootKey []byte) ([]byte, error) {
 
 	// This creates a dummy cipher just to get the nonce size. It's not used for actual decryption.
 	dummyBlock, err := aes.NewCipher(make([]byte, 32))
@@ -89,9 +145,16 @@ func DecryptMessage(encryptedMessage, encryptedDataKey []byte, keyVersions map[u
 	}
 	nonce, ciphertext := encryptedMessage[:nonceSize], encryptedMessage[nonceSize:]
 
+	// Legacy ciphertexts do not name their key, so the versions are tried newest first.
+	versions := make([]uint32, 0, len(keyVersions))
+	for version := range keyVersions {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
 	// This loop tries to decrypt the message using each of the provided master keys
 	// For each master key:
-	// a. Create a cipher and GCM instance using the master key.
+	// a. Unwrap the master key with the root key and create a cipher and GCM instance from it.
 	// b. Extract the master nonce from the encrypted data key.
 	// c. Attempt to decrypt the data key using the master key.
 	// d. If successful, use the decrypted data key to create a new cipher and GCM instance.
@@ -99,12 +162,14 @@ func DecryptMessage(encryptedMessage, encryptedDataKey []byte, keyVersions map[u
 	// f. If successful, break the loop.
 	var decryptedMessage []byte
 	var decryptionErr error
-	for _, masterKey := range keyVersions {
-		masterBlock, err := aes.NewCipher(masterKey.EncryptedKeyMaterial)
+	for _, version := range versions {
+		masterKey := keyVersions[version]
+		masterKeyMaterial, err := UnwrapKey(rootKey, masterKey.KeyID[:], masterKey.EncryptedKeyMaterial)
 		if err != nil {
+			decryptionErr = err
 			continue
 		}
-		masterGCM, err := cipher.NewGCM(masterBlock)
+		masterGCM, err := newGCM(masterKeyMaterial)
 		if err != nil {
 			continue
 		}
@@ -118,11 +183,7 @@ func DecryptMessage(encryptedMessage, encryptedDataKey []byte, keyVersions map[u
 			continue
 		}
 
-		block, err := aes.NewCipher(dataKey)
-		if err != nil {
-			continue
-		}
-		gcm, err := cipher.NewGCM(block)
+		gcm, err := newGCM(dataKey)
 		if err != nil {
 			continue
 		}
@@ -147,6 +208,13 @@ func DecryptMessage(encryptedMessage, encryptedDataKey []byte, keyVersions map[u
 	}
 
 	// This removes the 4-byte version information that was prepended to the message during encryption.
-	binary.BigEndian.Uint32(decryptedMessage[:4])
 	return decryptedMessage[4:], nil
 }
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// DataKeySize is the size in bytes of the data keys (DEKs) that encrypt payloads.
+const DataKeySize = 32
+
+// dataKeyUse is bound into the wrap of every data key, so a wrapped data key only unwraps
+// for the use it was made for. The envelope header is not authenticated, without it a
+// message envelope stripped of its nonce and ciphertext would unwrap in DecryptDataKey.
+type dataKeyUse byte
+
+const (
+	dataKeyForMessage dataKeyUse = 1
+	dataKeyForExport  dataKeyUse = 2
+	dataKeyForStream  dataKeyUse = 3
+)
+
+// GenerateDataKey returns a fresh data key in plaintext together with a wrapped copy.
+// The wrapped copy is an envelope without nonce or ciphertext, so it names the master
+// key version it was wrapped under just like an encrypted message does.
+func GenerateDataKey(encryptionContext EncryptionContext, masterKey *MasterKey) ([]byte, []byte, error) {
+	dataKey, err := newDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrappedDataKey, err := wrapDataKey(dataKey, dataKeyForExport, encryptionContext.Canonical(), masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	envelope := Envelope{
+		Algorithm:      masterKey.suite.ID,
+		KeyID:          masterKey.KeyID,
+		KeyVersion:     uint32(masterKey.Version),
+		WrappedDataKey: wrappedDataKey,
+	}
+	blob, err := envelope.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, blob, nil
+}
+
+// DecryptDataKey unwraps a data key produced by GenerateDataKey. Envelopes of encrypted
+// messages are refused, their data key never leaves the service.
+func DecryptDataKey(envelope *Envelope, encryptionContext EncryptionContext, masterKey *MasterKey) ([]byte, error) {
+	if len(envelope.Nonce) != 0 || len(envelope.Ciphertext) != 0 {
+		return nil, errors.New("ciphertext is an encrypted message, not a wrapped data key")
+	}
+	return unwrapDataKey(envelope, dataKeyForExport, encryptionContext.Canonical(), masterKey)
+}
+
+// newDataKey returns a random data key of DataKeySize bytes.
+func newDataKey() ([]byte, error) {
+	dataKey := make([]byte, DataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	return dataKey, nil
+}
+
+// wrapDataKey encrypts the data key under the master key with the key's suite, the
+// master nonce is prepended. The use and the aad are both authenticated, unwrapDataKey
+// must be given the same.
+func wrapDataKey(dataKey []byte, use dataKeyUse, aad []byte, masterKey *MasterKey) ([]byte, error) {
+	if err := masterKey.requirePurpose(model.KeyPurposeEncrypt); err != nil {
+		return nil, err
+	}
+	masterAEAD, err := masterKey.suite.New(masterKey.material)
+	if err != nil {
+		return nil, err
+	}
+	masterNonce := make([]byte, masterAEAD.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, masterNonce); err != nil {
+		return nil, err
+	}
+	return masterAEAD.Seal(masterNonce, masterNonce, dataKey, wrapAAD(use, aad)), nil
+}
+
+func unwrapDataKey(envelope *Envelope, use dataKeyUse, aad []byte, masterKey *MasterKey) ([]byte, error) {
+	if err := masterKey.requirePurpose(model.KeyPurposeEncrypt); err != nil {
+		return nil, err
+	}
+	if err := masterKey.matchEnvelope(envelope); err != nil {
+		return nil, err
+	}
+
+	// This separates the master nonce from the wrapped data key and unwraps it.
+	masterAEAD, err := masterKey.suite.New(masterKey.material)
+	if err != nil {
+		return nil, err
+	}
+	masterNonceSize := masterAEAD.NonceSize()
+	if len(envelope.WrappedDataKey) < masterNonceSize {
+		return nil, errors.New("wrapped data key is too short")
+	}
+	masterNonce, wrappedDataKey := envelope.WrappedDataKey[:masterNonceSize], envelope.WrappedDataKey[masterNonceSize:]
+	dataKey, err := masterAEAD.Open(nil, masterNonce, wrappedDataKey, wrapAAD(use, aad))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// wrapAAD prefixes the use to the additional authenticated data of a data key wrap.
+func wrapAAD(use dataKeyUse, aad []byte) []byte {
+	return append([]byte{byte(use)}, aad...)
+}
@@ -0,0 +1,107 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func newTestMasterKey(t *testing.T, algorithm string) *MasterKey {
+	t.Helper()
+	rootKey := make([]byte, RootKeySize)
+	if _, err := rand.Read(rootKey); err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewKeyVersion("test", 1, algorithm, rootKey)
+	if err != nil {
+		t.Fatalf("NewKeyVersion: %v", err)
+	}
+	masterKey, err := UnwrapMasterKey(key, rootKey)
+	if err != nil {
+		t.Fatalf("UnwrapMasterKey: %v", err)
+	}
+	return masterKey
+}
+
+func TestDataKeyRoundTrip(t *testing.T) {
+	masterKey := newTestMasterKey(t, DefaultAlgorithm)
+	encryptionContext := EncryptionContext{"tenant": "a"}
+
+	dataKey, blob, err := GenerateDataKey(encryptionContext, masterKey)
+	if err != nil {
+		t.Fatalf("GenerateDataKey: %v", err)
+	}
+	envelope, err := ParseEnvelope(blob)
+	if err != nil {
+		t.Fatalf("ParseEnvelope: %v", err)
+	}
+
+	got, err := DecryptDataKey(envelope, encryptionContext, masterKey)
+	if err != nil {
+		t.Fatalf("DecryptDataKey: %v", err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Fatal("unwrapped data key does not match the generated one")
+	}
+
+	if _, err := DecryptDataKey(envelope, EncryptionContext{"tenant": "b"}, masterKey); err == nil {
+		t.Fatal("DecryptDataKey succeeded with a different encryption context")
+	}
+}
+
+func TestDecryptDataKeyRefusesStrippedMessage(t *testing.T) {
+	masterKey := newTestMasterKey(t, DefaultAlgorithm)
+	encryptionContext := EncryptionContext{"tenant": "a"}
+
+	ciphertext, err := EncryptMessage([]byte("secret"), encryptionContext, masterKey)
+	if err != nil {
+		t.Fatalf("EncryptMessage: %v", err)
+	}
+	envelope, err := ParseEnvelope(ciphertext)
+	if err != nil {
+		t.Fatalf("ParseEnvelope: %v", err)
+	}
+	if _, err := DecryptDataKey(envelope, encryptionContext, masterKey); err == nil {
+		t.Fatal("DecryptDataKey accepted a message envelope")
+	}
+
+	// Dropping the payload makes the envelope look like one from GenerateDataKey.
+	envelope.Nonce, envelope.Ciphertext = nil, nil
+	stripped, err := envelope.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	envelope, err = ParseEnvelope(stripped)
+	if err != nil {
+		t.Fatalf("ParseEnvelope: %v", err)
+	}
+	if _, err := DecryptDataKey(envelope, encryptionContext, masterKey); err == nil {
+		t.Fatal("DecryptDataKey unwrapped the data key of a stripped message envelope")
+	}
+}
+
+func TestDecryptMessageRefusesExportedDataKey(t *testing.T) {
+	masterKey := newTestMasterKey(t, DefaultAlgorithm)
+	encryptionContext := EncryptionContext{"tenant": "a"}
+
+	dataKey, blob, err := GenerateDataKey(encryptionContext, masterKey)
+	if err != nil {
+		t.Fatalf("GenerateDataKey: %v", err)
+	}
+	envelope, err := ParseEnvelope(blob)
+	if err != nil {
+		t.Fatalf("ParseEnvelope: %v", err)
+	}
+
+	// A caller holding the data key can build a payload, it must still not pass as a
+	// message the service encrypted.
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope.Nonce = make([]byte, gcm.NonceSize())
+	envelope.Ciphertext = gcm.Seal(nil, envelope.Nonce, []byte("forged"), encryptionContext.Canonical())
+	if _, err := DecryptMessage(envelope, encryptionContext, masterKey); err == nil {
+		t.Fatal("DecryptMessage accepted an envelope built on an exported data key")
+	}
+}
@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// Envelope layout, all integers big endian:
+//
+//	magic           2 bytes  "VE"
+//	format version  1 byte
+//	algorithm       1 byte
+//	key id          16 bytes  UUID of the master key version
+//	key version     4 bytes
+//	wrapped dek len 2 bytes
+//	wrapped dek     n bytes   master key nonce followed by the sealed data key
+//	nonce len       1 byte
+//	nonce           n bytes
+//	ciphertext      remaining bytes
+//
+// Deterministic envelopes have no data key and no nonce, the ciphertext is sealed under
+// the master key itself.
+const (
+	envelopeMagic0 = 'V'
+	envelopeMagic1 = 'E'
+
+	EnvelopeFormatV1 byte = 1
+
+	envelopeHeaderSize = 2 + 1 + 1 + 16 + 4
+)
+
+// AlgorithmID identifies the AEAD used for both the data key wrap and the payload,
+// see SuiteByID.
+type AlgorithmID byte
+
+const (
+	AlgorithmAES256GCM         AlgorithmID = 1
+	AlgorithmChaCha20Poly1305  AlgorithmID = 2
+	AlgorithmXChaCha20Poly1305 AlgorithmID = 3
+	AlgorithmAES256SIV         AlgorithmID = 4
+)
+
+var ErrNotEnvelope = errors.New("ciphertext is not a versioned envelope")
+
+type Envelope struct {
+	Algorithm      AlgorithmID
+	KeyID          uuid.UUID
+	KeyVersion     uint32
+	WrappedDataKey []byte
+	Nonce          []byte
+	Ciphertext     []byte
+}
+
+// IsEnvelope reports whether data starts with the envelope magic and a known format version.
+// Legacy ciphertexts start with a random nonce, so a false positive only sends them down
+// the envelope path where parsing or the key lookup fails.
+func IsEnvelope(data []byte) bool {
+	return len(data) >= 3 && data[0] == envelopeMagic0 && data[1] == envelopeMagic1 && data[2] == EnvelopeFormatV1
+}
+
+func (e *Envelope) MarshalBinary() ([]byte, error) {
+	if len(e.WrappedDataKey) > 0xFFFF {
+		return nil, errors.New("wrapped data key is too long")
+	}
+	if len(e.Nonce) > 0xFF {
+		return nil, errors.New("nonce is too long")
+	}
+
+	size := envelopeHeaderSize + 2 + len(e.WrappedDataKey) + 1 + len(e.Nonce) + len(e.Ciphertext)
+	buf := make([]byte, 0, size)
+	buf = append(buf, envelopeMagic0, envelopeMagic1, EnvelopeFormatV1, byte(e.Algorithm))
+	buf = append(buf, e.KeyID[:]...)
+	buf = binary.BigEndian.AppendUint32(buf, e.KeyVersion)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(e.WrappedDataKey)))
+	buf = append(buf, e.WrappedDataKey...)
+	buf = append(buf, byte(len(e.Nonce)))
+	buf = append(buf, e.Nonce...)
+	buf = append(buf, e.Ciphertext...)
+	return buf, nil
+}
+
+// ParseEnvelope decodes an envelope. The returned slices alias data.
+func ParseEnvelope(data []byte) (*Envelope, error) {
+	if !IsEnvelope(data) {
+		return nil, ErrNotEnvelope
+	}
+	if len(data) < envelopeHeaderSize+2 {
+		return nil, errors.New("envelope is truncated")
+	}
+
+	var e Envelope
+	e.Algorithm = AlgorithmID(data[3])
+	copy(e.KeyID[:], data[4:20])
+	e.KeyVersion = binary.BigEndian.Uint32(data[20:24])
+	rest := data[envelopeHeaderSize:]
+
+	wrappedLen := int(binary.BigEndian.Uint16(rest[:2]))
+	rest = rest[2:]
+	if len(rest) < wrappedLen+1 {
+		return nil, errors.New("envelope is truncated")
+	}
+	e.WrappedDataKey, rest = rest[:wrappedLen], rest[wrappedLen:]
+
+	nonceLen := int(rest[0])
+	rest = rest[1:]
+	if len(rest) < nonceLen {
+		return nil, errors.New("envelope is truncated")
+	}
+	e.Nonce, e.Ciphertext = rest[:nonceLen], rest[nonceLen:]
+
+	if _, err := SuiteByID(e.Algorithm); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
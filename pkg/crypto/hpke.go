@@ -0,0 +1,144 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// HPKE (RFC 9180) in base mode with DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and
+// AES-256-GCM. Messages are sealed single-shot: the ciphertext is the 32-byte
+// encapsulated key followed by the AEAD output, with sequence number 0.
+const (
+	hpkeModeBase        = 0x00
+	hpkeKEMX25519SHA256 = 0x0020
+	hpkeKDFHKDFSHA256   = 0x0001
+	hpkeAEADAES256GCM   = 0x0002
+
+	hpkeEncSize   = 32
+	hpkeKeySize   = 32
+	hpkeNonceSize = 12
+)
+
+var (
+	hpkeKEMSuiteID = binary.BigEndian.AppendUint16([]byte("KEM"), hpkeKEMX25519SHA256)
+	hpkeSuiteID    = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(
+		binary.BigEndian.AppendUint16([]byte("HPKE"), hpkeKEMX25519SHA256), hpkeKDFHKDFSHA256), hpkeAEADAES256GCM)
+)
+
+func generateX25519() (privateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// hpkeSeal encrypts plaintext to the recipient's public key.
+func hpkeSeal(recipient *ecdh.PublicKey, info, aad, plaintext []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return hpkeSealWithEphemeral(ephemeral, recipient, info, aad, plaintext)
+}
+
+// hpkeSealWithEphemeral is hpkeSeal with a given ephemeral key, which must never be
+// reused. Only the known-answer tests pick it.
+func hpkeSealWithEphemeral(ephemeral *ecdh.PrivateKey, recipient *ecdh.PublicKey, info, aad, plaintext []byte) ([]byte, error) {
+	dh, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	enc := ephemeral.PublicKey().Bytes()
+	sharedSecret, err := hpkeSharedSecret(dh, enc, recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	key, nonce, err := hpkeKeySchedule(sharedSecret, info)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(enc, nonce, plaintext, aad), nil
+}
+
+// hpkeOpen decrypts a ciphertext produced by hpkeSeal with the recipient's private key.
+func hpkeOpen(recipient *ecdh.PrivateKey, info, aad, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < hpkeEncSize {
+		return nil, errors.New("ciphertext is too short")
+	}
+	enc, sealed := ciphertext[:hpkeEncSize], ciphertext[hpkeEncSize:]
+	ephemeral, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		return nil, err
+	}
+	dh, err := recipient.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := hpkeSharedSecret(dh, enc, recipient.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	key, nonce, err := hpkeKeySchedule(sharedSecret, info)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	return plaintext, nil
+}
+
+// hpkeSharedSecret is ExtractAndExpand of DHKEM, RFC 9180 section 4.1.
+func hpkeSharedSecret(dh, enc, recipient []byte) ([]byte, error) {
+	prk := labeledExtract(hpkeKEMSuiteID, nil, "eae_prk", dh)
+	kemContext := append(append([]byte{}, enc...), recipient...)
+	return labeledExpand(hpkeKEMSuiteID, prk, "shared_secret", kemContext, sha256.Size)
+}
+
+// hpkeKeySchedule derives the AEAD key and base nonce in base mode, RFC 9180 section 5.1.
+func hpkeKeySchedule(sharedSecret, info []byte) ([]byte, []byte, error) {
+	pskIDHash := labeledExtract(hpkeSuiteID, nil, "psk_id_hash", nil)
+	infoHash := labeledExtract(hpkeSuiteID, nil, "info_hash", info)
+	context := append(append([]byte{hpkeModeBase}, pskIDHash...), infoHash...)
+
+	secret := labeledExtract(hpkeSuiteID, sharedSecret, "secret", nil)
+	key, err := labeledExpand(hpkeSuiteID, secret, "key", context, hpkeKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := labeledExpand(hpkeSuiteID, secret, "base_nonce", context, hpkeNonceSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, nonce, nil
+}
+
+func labeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	labeled := append(append(append([]byte("HPKE-v1"), suiteID...), label...), ikm...)
+	return hkdf.Extract(sha256.New, labeled, salt)
+}
+
+func labeledExpand(suiteID, prk []byte, label string, info []byte, length int) ([]byte, error) {
+	labeled := binary.BigEndian.AppendUint16(nil, uint16(length))
+	labeled = append(append(append(append(labeled, "HPKE-v1"...), suiteID...), label...), info...)
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, labeled), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
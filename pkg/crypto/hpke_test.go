@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"testing"
+)
+
+// hpkeVector is a base mode test vector for DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and
+// AES-256-GCM with its first encryption, from the test vectors published with RFC 9180.
+// The appendix of the RFC only lists AES-128-GCM and ChaCha20-Poly1305.
+type hpkeVector struct {
+	info, skEm, pkEm, skRm, pkRm string
+	sharedSecret, key, baseNonce string
+	aad, plaintext, ciphertext   string
+}
+
+var rfc9180AES256GCM = hpkeVector{
+	info:         "4f6465206f6e2061204772656369616e2055726e",
+	skEm:         "179d4b53b6365c45b600c4163b61d95cbc2f4d9e36f1695558dce265ab8bab11",
+	pkEm:         "6c93e09869df3402d7bf231bf540fadd35cd56be14f97178f0954db94b7fc256",
+	skRm:         "497b4502664cfea5d5af0b39934dac72242a74f8480451e1aee7d6a53320333d",
+	pkRm:         "430f4b9859665145a6b1ba274024487bd66f03a2dd577d7753c68d7d7d00c00c",
+	sharedSecret: "3101c54c3a4f87439eaac080699ed9bbcc726ffe44e860c0424ccb7e3e2ead7b",
+	key:          "f50b0609186798729ed0564b36ef2ef8044f1f9d05636874d1f46c819c7a669f",
+	baseNonce:    "151d9929e2449747889bc923",
+	aad:          "436f756e742d30",
+	plaintext:    "4265617574792069732074727574682c20747275746820626561757479",
+	ciphertext:   "e5d84cd531cfb583096e7cfa9641bd3079cf3a91cda813c52deb5f512be9931980a41de125a925cdad859d5b7a",
+}
+
+func hpkeVectorKeys(t *testing.T, v hpkeVector) (*ecdh.PrivateKey, *ecdh.PrivateKey) {
+	t.Helper()
+	ephemeral, err := ecdh.X25519().NewPrivateKey(unhex(t, v.skEm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := ecdh.X25519().NewPrivateKey(unhex(t, v.skRm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ephemeral.PublicKey().Bytes(), unhex(t, v.pkEm)) {
+		t.Fatalf("pkEm = %x, want %s", ephemeral.PublicKey().Bytes(), v.pkEm)
+	}
+	if !bytes.Equal(recipient.PublicKey().Bytes(), unhex(t, v.pkRm)) {
+		t.Fatalf("pkRm = %x, want %s", recipient.PublicKey().Bytes(), v.pkRm)
+	}
+	return ephemeral, recipient
+}
+
+func TestHPKEKeySchedule(t *testing.T) {
+	v := rfc9180AES256GCM
+	ephemeral, recipient := hpkeVectorKeys(t, v)
+
+	dh, err := ephemeral.ECDH(recipient.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedSecret, err := hpkeSharedSecret(dh, unhex(t, v.pkEm), unhex(t, v.pkRm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sharedSecret, unhex(t, v.sharedSecret)) {
+		t.Fatalf("shared_secret = %x, want %s", sharedSecret, v.sharedSecret)
+	}
+
+	key, nonce, err := hpkeKeySchedule(sharedSecret, unhex(t, v.info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, unhex(t, v.key)) {
+		t.Fatalf("key = %x, want %s", key, v.key)
+	}
+	if !bytes.Equal(nonce, unhex(t, v.baseNonce)) {
+		t.Fatalf("base_nonce = %x, want %s", nonce, v.baseNonce)
+	}
+}
+
+func TestHPKESealOpenVector(t *testing.T) {
+	v := rfc9180AES256GCM
+	ephemeral, recipient := hpkeVectorKeys(t, v)
+	want := append(unhex(t, v.pkEm), unhex(t, v.ciphertext)...)
+
+	sealed, err := hpkeSealWithEphemeral(ephemeral, recipient.PublicKey(), unhex(t, v.info), unhex(t, v.aad), unhex(t, v.plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sealed, want) {
+		t.Fatalf("hpkeSeal = %x, want %x", sealed, want)
+	}
+
+	plaintext, err := hpkeOpen(recipient, unhex(t, v.info), unhex(t, v.aad), want)
+	if err != nil {
+		t.Fatalf("hpkeOpen: %v", err)
+	}
+	if !bytes.Equal(plaintext, unhex(t, v.plaintext)) {
+		t.Fatalf("hpkeOpen = %x, want %s", plaintext, v.plaintext)
+	}
+}
+
+func TestHPKEOpenRejectsTampering(t *testing.T) {
+	recipient, err := generateX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	private := recipient.(*ecdh.PrivateKey)
+	aad := []byte("context")
+	sealed, err := hpkeSeal(private.PublicKey(), nil, aad, []byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := generateX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hpkeOpen(other.(*ecdh.PrivateKey), nil, aad, sealed); err == nil {
+		t.Error("hpkeOpen accepted another recipient")
+	}
+	if _, err := hpkeOpen(private, nil, []byte("other"), sealed); err == nil {
+		t.Error("hpkeOpen accepted other additional data")
+	}
+	for _, i := range []int{0, hpkeEncSize, len(sealed) - 1} {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 1
+		if _, err := hpkeOpen(private, nil, aad, tampered); err == nil {
+			t.Errorf("hpkeOpen accepted a ciphertext with byte %d flipped", i)
+		}
+	}
+	if _, err := hpkeOpen(private, nil, aad, sealed[:hpkeEncSize-1]); err == nil {
+		t.Error("hpkeOpen accepted a ciphertext shorter than the encapsulated key")
+	}
+}
@@ -0,0 +1,65 @@
+package crypto
+
+import (
+	"crypto/x509"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// NewKeyVersion creates an ACTIVE version of the named key with fresh material wrapped
+// under the root key. Versions expire one year after creation. An empty algorithm
+// selects DefaultAlgorithm.
+func NewKeyVersion(name string, version int, algorithm string, rootKey []byte) (*model.EncryptionKey, error) {
+	suite, err := SuiteByName(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	key := model.EncryptionKey{
+		KeyID:          uuid.New(),
+		Name:           name,
+		CreationDate:   now,
+		Status:         string(model.KeyStatusActive),
+		Version:        version,
+		ExpirationDate: now.AddDate(1, 0, 0), // 1 year from now
+		Algorithm:      suite.Name,
+	}
+
+	if suite.GenerateKey != nil {
+		if err := generateKeyPair(&key, suite, rootKey); err != nil {
+			return nil, err
+		}
+		return &key, nil
+	}
+
+	// The master key is generated and wrapped under the root key, raw material never reaches the database.
+	wrappedKey, err := GenerateMasterKey(rootKey, key.KeyID[:], suite.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	key.EncryptedKeyMaterial = wrappedKey
+	return &key, nil
+}
+
+// generateKeyPair wraps the PKCS #8 encoded private half of a fresh asymmetric key
+// under the root key like symmetric material, and stores the public half in the clear.
+func generateKeyPair(key *model.EncryptionKey, suite *Suite, rootKey []byte) error {
+	private, err := suite.GenerateKey()
+	if err != nil {
+		return err
+	}
+	material, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+	defer clear(material)
+	key.PublicKey, err = x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return err
+	}
+	key.EncryptedKeyMaterial, err = WrapKey(rootKey, key.KeyID[:], material)
+	return err
+}
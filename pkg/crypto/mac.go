@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// GenerateMAC returns the HMAC of message under a mac key, tagged with the key version
+// as "v<version>:<base64 mac>" so VerifyMAC can pick the version after rotations.
+func GenerateMAC(message []byte, masterKey *MasterKey) (string, error) {
+	if err := masterKey.requirePurpose(model.KeyPurposeMAC); err != nil {
+		return "", err
+	}
+	mac := hmac.New(masterKey.suite.Hash, masterKey.material)
+	mac.Write(message)
+	return "v" + strconv.Itoa(masterKey.Version) + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// ParseMACTag splits a tag produced by GenerateMAC into the key version and the raw MAC.
+func ParseMACTag(tag string) (int, []byte, error) {
+	prefix, encoded, ok := strings.Cut(tag, ":")
+	if !ok || !strings.HasPrefix(prefix, "v") {
+		return 0, nil, errors.New(`mac must have the form "v<version>:<base64>"`)
+	}
+	version, err := strconv.Atoi(prefix[1:])
+	if err != nil || version < 1 {
+		return 0, nil, fmt.Errorf("mac has an invalid key version %q", prefix[1:])
+	}
+	mac, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, nil, errors.New("mac is not valid base64")
+	}
+	return version, mac, nil
+}
+
+// VerifyMAC reports whether mac is the HMAC of message under the given version of a mac
+// key. The comparison is constant time.
+func VerifyMAC(message, mac []byte, masterKey *MasterKey) (bool, error) {
+	if err := masterKey.requirePurpose(model.KeyPurposeMAC); err != nil {
+		return false, err
+	}
+	expected := hmac.New(masterKey.suite.Hash, masterKey.material)
+	expected.Write(message)
+	return hmac.Equal(expected.Sum(nil), mac), nil
+}
@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// ErrKeyPurpose is returned when a master key is used for an operation its purpose
+// does not allow.
+var ErrKeyPurpose = errors.New("the purpose of the key does not allow this operation")
+
+// MasterKey is a master key version together with its unwrapped material. It only ever
+// lives in memory, the stored row keeps the material wrapped under the root key.
+type MasterKey struct {
+	*model.EncryptionKey
+	suite    *Suite
+	material []byte
+	// private is the parsed material of asymmetric versions.
+	private privateKey
+}
+
+// UnwrapMasterKey unwraps the material of a stored master key version with the root key.
+func UnwrapMasterKey(key *model.EncryptionKey, rootKey []byte) (*MasterKey, error) {
+	suite, err := SuiteByName(key.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	material, err := UnwrapKey(rootKey, key.KeyID[:], key.EncryptedKeyMaterial)
+	if err != nil {
+		return nil, err
+	}
+
+	masterKey := &MasterKey{EncryptionKey: key, suite: suite, material: material}
+	if suite.GenerateKey != nil {
+		parsed, err := x509.ParsePKCS8PrivateKey(material)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		private, ok := parsed.(privateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", parsed)
+		}
+		masterKey.private = private
+	}
+	return masterKey, nil
+}
+
+// Suite returns the cipher suite of the key.
+func (k *MasterKey) Suite() *Suite {
+	return k.suite
+}
+
+// requirePurpose fails with ErrKeyPurpose unless the key's suite has the given purpose.
+func (k *MasterKey) requirePurpose(purpose model.KeyPurpose) error {
+	if k.suite.Purpose != purpose {
+		return fmt.Errorf("%w: key %q has purpose %s", ErrKeyPurpose, k.Name, k.suite.Purpose)
+	}
+	return nil
+}
+
+// matchEnvelope checks that the envelope names this key version and its suite.
+// Only the key id is compared, it is unique per version. The version in the header is
+// informational, versions of old rows may have been renumbered when duplicate versions
+// were cleaned up.
+func (k *MasterKey) matchEnvelope(envelope *Envelope) error {
+	if envelope.KeyID != k.KeyID {
+		return errors.New("envelope was not encrypted with this master key")
+	}
+	if envelope.Algorithm != k.suite.ID {
+		return fmt.Errorf("envelope algorithm %d does not match the %s master key", envelope.Algorithm, k.suite.Name)
+	}
+	return nil
+}
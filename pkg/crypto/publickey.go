@@ -0,0 +1,89 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// ErrNoPublicKey is returned when the public key of a symmetric version is requested.
+var ErrNoPublicKey = errors.New("key has no public key")
+
+// JWK is a public key as a JSON Web Key (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// PublicKeyPEM returns the public half of an asymmetric version as a PKIX "PUBLIC KEY"
+// PEM block. It only reads the stored public key, nothing is unwrapped.
+func PublicKeyPEM(key *model.EncryptionKey) (string, error) {
+	if len(key.PublicKey) == 0 {
+		return "", ErrNoPublicKey
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: key.PublicKey})), nil
+}
+
+// PublicKeyJWK returns the public half of an asymmetric version as a JWK whose kid is
+// the key id of the version.
+func PublicKeyJWK(key *model.EncryptionKey) (*JWK, error) {
+	if len(key.PublicKey) == 0 {
+		return nil, ErrNoPublicKey
+	}
+	suite, err := SuiteByName(key.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	jwk := &JWK{KeyID: key.KeyID.String(), Algorithm: suite.JWKAlgorithm}
+	switch suite.Purpose {
+	case model.KeyPurposeSign:
+		jwk.Use = "sig"
+	case model.KeyPurposeAsymmetricDecrypt:
+		jwk.Use = "enc"
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType, jwk.Curve, jwk.X = "OKP", "Ed25519", b64(pub)
+	case *ecdh.PublicKey:
+		// ParsePKIXPublicKey returns X25519 keys as ecdh keys, RFC 8037 names them OKP.
+		jwk.KeyType, jwk.Curve, jwk.X = "OKP", "X25519", b64(pub.Bytes())
+	case *ecdsa.PublicKey:
+		ecdhKey, err := pub.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		// The uncompressed point is 0x04 followed by X and Y of equal length.
+		point := ecdhKey.Bytes()[1:]
+		size := len(point) / 2
+		jwk.KeyType, jwk.Curve = "EC", pub.Curve.Params().Name
+		jwk.X, jwk.Y = b64(point[:size]), b64(point[size:])
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N, jwk.E = b64(pub.N.Bytes()), b64(big.NewInt(int64(pub.E)).Bytes())
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return jwk, nil
+}
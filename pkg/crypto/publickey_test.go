@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestPublicKeyPEM(t *testing.T) {
+	for _, algorithm := range testSignSuites {
+		t.Run(algorithm, func(t *testing.T) {
+			masterKey := newTestMasterKey(t, algorithm)
+			encoded, err := PublicKeyPEM(masterKey.EncryptionKey)
+			if err != nil {
+				t.Fatalf("PublicKeyPEM: %v", err)
+			}
+			block, rest := pem.Decode([]byte(encoded))
+			if block == nil || block.Type != "PUBLIC KEY" || len(rest) != 0 {
+				t.Fatalf("PublicKeyPEM returned %q, want a single PUBLIC KEY block", encoded)
+			}
+			pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				t.Fatalf("ParsePKIXPublicKey: %v", err)
+			}
+			want, ok := masterKey.private.Public().(interface{ Equal(gocrypto.PublicKey) bool })
+			if !ok || !want.Equal(pub) {
+				t.Fatal("the PEM public key does not match the private half")
+			}
+		})
+	}
+}
+
+func TestPublicKeyJWK(t *testing.T) {
+	b64 := base64.RawURLEncoding.DecodeString
+	tests := []struct {
+		algorithm string
+		kty, crv  string
+		alg       string
+	}{
+		{AlgorithmNameEd25519, "OKP", "Ed25519", "EdDSA"},
+		{AlgorithmNameECDSAP256SHA256, "EC", "P-256", "ES256"},
+		{AlgorithmNameRSAPSS2048SHA256, "RSA", "", "PS256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			masterKey := newTestMasterKey(t, tt.algorithm)
+			jwk, err := PublicKeyJWK(masterKey.EncryptionKey)
+			if err != nil {
+				t.Fatalf("PublicKeyJWK: %v", err)
+			}
+			if jwk.KeyType != tt.kty || jwk.Curve != tt.crv || jwk.Algorithm != tt.alg || jwk.Use != "sig" ||
+				jwk.KeyID != masterKey.KeyID.String() {
+				t.Fatalf("PublicKeyJWK returned %+v", jwk)
+			}
+
+			switch pub := masterKey.private.Public().(type) {
+			case ed25519.PublicKey:
+				x, err := b64(jwk.X)
+				if err != nil || !pub.Equal(ed25519.PublicKey(x)) {
+					t.Fatalf("x is %q, want the Ed25519 public key", jwk.X)
+				}
+			case *ecdsa.PublicKey:
+				x, errX := b64(jwk.X)
+				y, errY := b64(jwk.Y)
+				// Coordinates are padded to the size of the curve.
+				if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+					t.Fatalf("x and y are %q and %q, want 32 bytes each", jwk.X, jwk.Y)
+				}
+				if new(big.Int).SetBytes(x).Cmp(pub.X) != 0 || new(big.Int).SetBytes(y).Cmp(pub.Y) != 0 {
+					t.Fatal("x and y do not match the public point")
+				}
+			case *rsa.PublicKey:
+				n, errN := b64(jwk.N)
+				e, errE := b64(jwk.E)
+				if errN != nil || errE != nil || new(big.Int).SetBytes(n).Cmp(pub.N) != 0 ||
+					new(big.Int).SetBytes(e).Int64() != int64(pub.E) {
+					t.Fatalf("n and e are %q and %q, want the RSA modulus and exponent", jwk.N, jwk.E)
+				}
+				if jwk.E != "AQAB" {
+					t.Fatalf("e is %q, want AQAB", jwk.E)
+				}
+			default:
+				t.Fatalf("unexpected public key type %T", pub)
+			}
+		})
+	}
+}
+
+func TestPublicKeyOfSymmetricKey(t *testing.T) {
+	masterKey := newTestMasterKey(t, DefaultAlgorithm)
+	if _, err := PublicKeyPEM(masterKey.EncryptionKey); !errors.Is(err, ErrNoPublicKey) {
+		t.Fatalf("PublicKeyPEM returned %v, want ErrNoPublicKey", err)
+	}
+	if _, err := PublicKeyJWK(masterKey.EncryptionKey); !errors.Is(err, ErrNoPublicKey) {
+		t.Fatalf("PublicKeyJWK returned %v, want ErrNoPublicKey", err)
+	}
+}
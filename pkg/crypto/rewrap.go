@@ -0,0 +1,53 @@
+package crypto
+
+import (
+	"fmt"
+
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// RewrapEnvelope moves an envelope from the master key version it names to newKey.
+// Only the data key is unwrapped and wrapped again, the payload is copied untouched,
+// so the plaintext is never decrypted. Wrapped data keys from GenerateDataKey are
+// rewrapped the same way. Deterministic envelopes have no data key, they are decrypted
+// and encrypted again in memory.
+func RewrapEnvelope(envelope *Envelope, encryptionContext EncryptionContext, oldKey, newKey *MasterKey) ([]byte, error) {
+	// The payload stays encrypted under the old suite, so both keys must share it.
+	if oldKey.suite != newKey.suite {
+		return nil, fmt.Errorf("cannot rewrap from %s to %s", oldKey.suite.Name, newKey.suite.Name)
+	}
+	if oldKey.suite.Purpose == model.KeyPurposeDeterministic {
+		message, err := decryptDeterministic(envelope, encryptionContext, oldKey)
+		if err != nil {
+			return nil, err
+		}
+		return EncryptDeterministic(message, encryptionContext, newKey)
+	}
+
+	// A wrapped data key from GenerateDataKey is the only envelope without a payload.
+	use := dataKeyForMessage
+	if len(envelope.Nonce) == 0 && len(envelope.Ciphertext) == 0 {
+		use = dataKeyForExport
+	}
+
+	aad := encryptionContext.Canonical()
+	dataKey, err := unwrapDataKey(envelope, use, aad, oldKey)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedDataKey, err := wrapDataKey(dataKey, use, aad, newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rewrapped := Envelope{
+		Algorithm:      envelope.Algorithm,
+		KeyID:          newKey.KeyID,
+		KeyVersion:     uint32(newKey.Version),
+		WrappedDataKey: wrappedDataKey,
+		Nonce:          envelope.Nonce,
+		Ciphertext:     envelope.Ciphertext,
+	}
+	return rewrapped.MarshalBinary()
+}
@@ -0,0 +1,130 @@
+package crypto
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/valu/encrpytion/internal/model"
+)
+
+// privateKey is implemented by the private keys of every asymmetric suite, they are
+// the types x509.ParsePKCS8PrivateKey returns.
+type privateKey interface {
+	Public() gocrypto.PublicKey
+}
+
+func generateEd25519() (privateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+func generateECDSA(curve elliptic.Curve) func() (privateKey, error) {
+	return func() (privateKey, error) {
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	}
+}
+
+func generateRSA(bits int) func() (privateKey, error) {
+	return func() (privateKey, error) {
+		return rsa.GenerateKey(rand.Reader, bits)
+	}
+}
+
+// ErrInvalidDigest is returned for a digest that does not fit the key's suite, either
+// because it has the wrong length or because the suite signs the message itself.
+var ErrInvalidDigest = errors.New("invalid digest")
+
+// Sign signs message with the private half of a sign key. ECDSA signatures are ASN.1
+// DER encoded, RSA signatures use PSS with a salt as long as the hash.
+func Sign(message []byte, masterKey *MasterKey) ([]byte, error) {
+	if err := masterKey.requirePurpose(model.KeyPurposeSign); err != nil {
+		return nil, err
+	}
+	return sign(digest(message, masterKey.suite.SignerOpts.HashFunc()), masterKey)
+}
+
+// SignDigest signs a digest the caller computed with the hash of the key's suite, so
+// large artifacts never have to be sent. The signature is the one Sign returns for the
+// message itself. Ed25519 hashes inside the signature and has no digest to sign.
+func SignDigest(hashed []byte, masterKey *MasterKey) ([]byte, error) {
+	if err := masterKey.requirePurpose(model.KeyPurposeSign); err != nil {
+		return nil, err
+	}
+	if err := checkDigest(hashed, masterKey); err != nil {
+		return nil, err
+	}
+	return sign(hashed, masterKey)
+}
+
+// Verify reports whether signature is a valid signature of message under the public
+// half of a sign key.
+func Verify(message, signature []byte, masterKey *MasterKey) (bool, error) {
+	if err := masterKey.requirePurpose(model.KeyPurposeSign); err != nil {
+		return false, err
+	}
+	return verify(digest(message, masterKey.suite.SignerOpts.HashFunc()), signature, masterKey)
+}
+
+// VerifyDigest is Verify for a digest the caller computed, see SignDigest.
+func VerifyDigest(hashed, signature []byte, masterKey *MasterKey) (bool, error) {
+	if err := masterKey.requirePurpose(model.KeyPurposeSign); err != nil {
+		return false, err
+	}
+	if err := checkDigest(hashed, masterKey); err != nil {
+		return false, err
+	}
+	return verify(hashed, signature, masterKey)
+}
+
+// sign signs the digest, or the message itself for suites without a hash.
+func sign(hashed []byte, masterKey *MasterKey) ([]byte, error) {
+	signer, ok := masterKey.private.(gocrypto.Signer)
+	if !ok {
+		return nil, errors.New("key cannot sign")
+	}
+	return signer.Sign(rand.Reader, hashed, masterKey.suite.SignerOpts)
+}
+
+func verify(hashed, signature []byte, masterKey *MasterKey) (bool, error) {
+	opts := masterKey.suite.SignerOpts
+	switch pub := masterKey.private.Public().(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, hashed, signature), nil
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(pub, hashed, signature), nil
+	case *rsa.PublicKey:
+		pss, _ := opts.(*rsa.PSSOptions)
+		return rsa.VerifyPSS(pub, opts.HashFunc(), hashed, signature, pss) == nil, nil
+	default:
+		return false, errors.New("key cannot verify")
+	}
+}
+
+// checkDigest returns ErrInvalidDigest unless hashed is a digest of the suite's hash.
+func checkDigest(hashed []byte, masterKey *MasterKey) error {
+	hash := masterKey.suite.SignerOpts.HashFunc()
+	if hash == 0 {
+		return fmt.Errorf("%w, %s keys sign the message itself", ErrInvalidDigest, masterKey.suite.Name)
+	}
+	if len(hashed) != hash.Size() {
+		return fmt.Errorf("%w, %s keys sign %s digests of %d bytes, got %d", ErrInvalidDigest,
+			masterKey.suite.Name, hash, hash.Size(), len(hashed))
+	}
+	return nil
+}
+
+// digest hashes message, or returns it as is for suites that sign the message itself.
+func digest(message []byte, hash gocrypto.Hash) []byte {
+	if hash == 0 {
+		return message
+	}
+	h := hash.New()
+	h.Write(message)
+	return h.Sum(nil)
+}
@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+var testSignSuites = []string{
+	AlgorithmNameEd25519, AlgorithmNameECDSAP256SHA256, AlgorithmNameRSAPSS2048SHA256, AlgorithmNameRSAPSS3072SHA256,
+}
+
+func TestSignRoundTrip(t *testing.T) {
+	message := []byte("release-1.4.2.tar.gz")
+	for _, algorithm := range testSignSuites {
+		t.Run(algorithm, func(t *testing.T) {
+			masterKey := newTestMasterKey(t, algorithm)
+
+			signature, err := Sign(message, masterKey)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			if valid, err := Verify(message, signature, masterKey); err != nil || !valid {
+				t.Fatalf("Verify returned %v, %v, want a valid signature", valid, err)
+			}
+			if valid, _ := Verify([]byte("release-1.4.3.tar.gz"), signature, masterKey); valid {
+				t.Fatal("Verify accepted the signature for another message")
+			}
+			tampered := append([]byte(nil), signature...)
+			tampered[len(tampered)-1] ^= 1
+			if valid, _ := Verify(message, tampered, masterKey); valid {
+				t.Fatal("Verify accepted a tampered signature")
+			}
+			if valid, _ := Verify(message, signature, newTestMasterKey(t, algorithm)); valid {
+				t.Fatal("Verify accepted the signature under another key")
+			}
+		})
+	}
+}
+
+func TestSignDigest(t *testing.T) {
+	message := []byte("release-1.4.2.tar.gz")
+	hashed := sha256.Sum256(message)
+	for _, algorithm := range testSignSuites[1:] {
+		t.Run(algorithm, func(t *testing.T) {
+			masterKey := newTestMasterKey(t, algorithm)
+
+			// A signature over the digest verifies against the message and the other way round.
+			signature, err := SignDigest(hashed[:], masterKey)
+			if err != nil {
+				t.Fatalf("SignDigest: %v", err)
+			}
+			if valid, err := Verify(message, signature, masterKey); err != nil || !valid {
+				t.Fatalf("Verify of a digest signature returned %v, %v", valid, err)
+			}
+			if signature, err = Sign(message, masterKey); err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			if valid, err := VerifyDigest(hashed[:], signature, masterKey); err != nil || !valid {
+				t.Fatalf("VerifyDigest of a message signature returned %v, %v", valid, err)
+			}
+
+			if _, err := SignDigest(hashed[:20], masterKey); !errors.Is(err, ErrInvalidDigest) {
+				t.Fatalf("SignDigest of a short digest returned %v, want ErrInvalidDigest", err)
+			}
+			if _, err := VerifyDigest(hashed[:20], signature, masterKey); !errors.Is(err, ErrInvalidDigest) {
+				t.Fatalf("VerifyDigest of a short digest returned %v, want ErrInvalidDigest", err)
+			}
+		})
+	}
+
+	// Ed25519 signs the message itself, there is no digest to sign.
+	masterKey := newTestMasterKey(t, AlgorithmNameEd25519)
+	if _, err := SignDigest(hashed[:], masterKey); !errors.Is(err, ErrInvalidDigest) {
+		t.Fatalf("SignDigest with an Ed25519 key returned %v, want ErrInvalidDigest", err)
+	}
+}
+
+func TestSignRequiresSignKey(t *testing.T) {
+	masterKey := newTestMasterKey(t, DefaultAlgorithm)
+	if _, err := Sign([]byte("message"), masterKey); !errors.Is(err, ErrKeyPurpose) {
+		t.Fatalf("Sign with an encrypt key returned %v, want ErrKeyPurpose", err)
+	}
+	if _, err := Verify([]byte("message"), []byte("signature"), masterKey); !errors.Is(err, ErrKeyPurpose) {
+		t.Fatalf("Verify with an encrypt key returned %v, want ErrKeyPurpose", err)
+	}
+}
@@ -0,0 +1,151 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+// aesSIV implements AES-SIV (RFC 5297) as a cipher.AEAD without a nonce. The synthetic
+// IV is a CMAC over the additional data and the plaintext, so the same inputs always
+// give the same ciphertext. The first half of the key keys S2V, the second half CTR.
+type aesSIV struct {
+	mac    cipher.Block
+	ctr    cipher.Block
+	k1, k2 [aes.BlockSize]byte // CMAC subkeys
+}
+
+var errSIVOpen = errors.New("crypto: message authentication failed")
+
+// newSIV accepts 32, 48 or 64-byte keys for AES-128, AES-192 and AES-256-SIV.
+func newSIV(key []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 32, 48, 64:
+	default:
+		return nil, fmt.Errorf("AES-SIV key must be 32, 48 or 64 bytes, got %d", len(key))
+	}
+	half := len(key) / 2
+	mac, err := aes.NewCipher(key[:half])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[half:])
+	if err != nil {
+		return nil, err
+	}
+
+	s := &aesSIV{mac: mac, ctr: ctr}
+	var l [aes.BlockSize]byte
+	mac.Encrypt(l[:], l[:])
+	s.k1 = dbl(l)
+	s.k2 = dbl(s.k1)
+	return s, nil
+}
+
+func (s *aesSIV) NonceSize() int { return 0 }
+
+func (s *aesSIV) Overhead() int { return aes.BlockSize }
+
+// Seal returns dst with the synthetic IV and the ciphertext appended. The output is
+// written to a fresh buffer first, so plaintext and dst may overlap.
+func (s *aesSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != 0 {
+		panic("crypto: AES-SIV takes no nonce")
+	}
+	v := s.s2v(additionalData, plaintext)
+	out := make([]byte, aes.BlockSize+len(plaintext))
+	copy(out, v[:])
+	s.xorCTR(out[aes.BlockSize:], plaintext, v)
+	return append(dst, out...)
+}
+
+func (s *aesSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != 0 || len(ciphertext) < aes.BlockSize {
+		return nil, errSIVOpen
+	}
+	var v [aes.BlockSize]byte
+	copy(v[:], ciphertext)
+	plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+	s.xorCTR(plaintext, ciphertext[aes.BlockSize:], v)
+
+	expected := s.s2v(additionalData, plaintext)
+	if subtle.ConstantTimeCompare(expected[:], v[:]) != 1 {
+		clear(plaintext)
+		return nil, errSIVOpen
+	}
+	return append(dst, plaintext...), nil
+}
+
+// xorCTR runs AES-CTR from the synthetic IV with bits 31 and 63 cleared, RFC 5297 section 2.5.
+func (s *aesSIV) xorCTR(dst, src []byte, v [aes.BlockSize]byte) {
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	cipher.NewCTR(s.ctr, v[:]).XORKeyStream(dst, src)
+}
+
+// s2v is the S2V construction of RFC 5297 section 2.4 over a vector of strings whose
+// last one is the plaintext. The AEAD methods use one additional data string.
+func (s *aesSIV) s2v(vector ...[]byte) [aes.BlockSize]byte {
+	var zero [aes.BlockSize]byte
+	d := s.cmac(zero[:])
+	for _, str := range vector[:len(vector)-1] {
+		d = xorBlock(dbl(d), s.cmac(str))
+	}
+
+	plaintext := vector[len(vector)-1]
+	if len(plaintext) >= aes.BlockSize {
+		t := make([]byte, len(plaintext))
+		copy(t, plaintext)
+		subtle.XORBytes(t[len(t)-aes.BlockSize:], t[len(t)-aes.BlockSize:], d[:])
+		return s.cmac(t)
+	}
+	var padded [aes.BlockSize]byte
+	copy(padded[:], plaintext)
+	padded[len(plaintext)] = 0x80
+	t := xorBlock(dbl(d), padded)
+	return s.cmac(t[:])
+}
+
+// cmac is AES-CMAC (RFC 4493) under the S2V key.
+func (s *aesSIV) cmac(msg []byte) [aes.BlockSize]byte {
+	var last [aes.BlockSize]byte
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	if n == 0 {
+		n = 1
+	}
+	tail := msg[(n-1)*aes.BlockSize:]
+	if len(tail) == aes.BlockSize {
+		last = xorBlock(s.k1, [aes.BlockSize]byte(tail))
+	} else {
+		copy(last[:], tail)
+		last[len(tail)] = 0x80
+		last = xorBlock(s.k2, last)
+	}
+
+	var x [aes.BlockSize]byte
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x[:], x[:], msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		s.mac.Encrypt(x[:], x[:])
+	}
+	x = xorBlock(x, last)
+	s.mac.Encrypt(x[:], x[:])
+	return x
+}
+
+// dbl multiplies a block by x in GF(2^128).
+func dbl(b [aes.BlockSize]byte) [aes.BlockSize]byte {
+	var out [aes.BlockSize]byte
+	carry := b[0] >> 7
+	for i := 0; i < aes.BlockSize-1; i++ {
+		out[i] = b[i]<<1 | b[i+1]>>7
+	}
+	out[aes.BlockSize-1] = b[aes.BlockSize-1]<<1 ^ carry*0x87
+	return out
+}
+
+func xorBlock(a, b [aes.BlockSize]byte) [aes.BlockSize]byte {
+	subtle.XORBytes(a[:], a[:], b[:])
+	return a
+}
@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 5297 appendix A.1, deterministic authenticated encryption.
+func TestSIVDeterministicVector(t *testing.T) {
+	key := unhex(t, "fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff")
+	ad := unhex(t, "10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627")
+	plaintext := unhex(t, "11223344 55667788 99aabbcc ddee")
+	want := unhex(t, "85632d07 c6e8f37f 950acd32 0a2ecc93 40c02b96 90c4dc04 daef7f6a fe5c")
+
+	aead, err := newSIV(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := aead.Seal(nil, nil, plaintext, ad)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Seal = %x, want %x", got, want)
+	}
+
+	opened, err := aead.Open(nil, nil, want, ad)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Fatalf("Open = %x, want %x", opened, plaintext)
+	}
+}
+
+// RFC 5297 appendix A.2, nonce-based authenticated encryption with two additional data
+// strings and the nonce as the last one before the plaintext.
+func TestSIVNonceBasedVector(t *testing.T) {
+	key := unhex(t, "7f7e7d7c 7b7a7978 77767574 73727170 40414243 44454647 48494a4b 4c4d4e4f")
+	ad1 := unhex(t, "00112233 44556677 8899aabb ccddeeff deaddada deaddada ffeeddcc bbaa9988 77665544 33221100")
+	ad2 := unhex(t, "10203040 50607080 90a0")
+	nonce := unhex(t, "09f91102 9d74e35b d84156c5 635688c0")
+	plaintext := unhex(t, "74686973 20697320 736f6d65 20706c61 696e7465 78742074 6f20656e 63727970 74207573 696e6720 5349562d 414553")
+	wantIV := unhex(t, "7bdb6e3b 432667eb 06f4d14b ff2fbd0f")
+	wantCiphertext := unhex(t, "cb900f2f ddbe4043 26601965 c889bf17 dba77ceb 094fa663 b7a3f748 ba8af829 ea64ad54 4a272e9c 485b62a3 fd5c0d")
+
+	aead, err := newSIV(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := aead.(*aesSIV)
+	iv := s.s2v(ad1, ad2, nonce, plaintext)
+	if !bytes.Equal(iv[:], wantIV) {
+		t.Fatalf("S2V = %x, want %x", iv, wantIV)
+	}
+	ciphertext := make([]byte, len(plaintext))
+	s.xorCTR(ciphertext, plaintext, iv)
+	if !bytes.Equal(ciphertext, wantCiphertext) {
+		t.Fatalf("ciphertext = %x, want %x", ciphertext, wantCiphertext)
+	}
+}
+
+func TestSIVOpenRejectsTampering(t *testing.T) {
+	aead, err := newSIV(make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad := []byte("context")
+	sealed := aead.Seal(nil, nil, []byte("a message longer than one block"), ad)
+
+	for i := range sealed {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 1
+		if _, err := aead.Open(nil, nil, tampered, ad); err == nil {
+			t.Fatalf("Open accepted a ciphertext with byte %d flipped", i)
+		}
+	}
+	if _, err := aead.Open(nil, nil, sealed, []byte("other")); err == nil {
+		t.Fatal("Open accepted other additional data")
+	}
+	if _, err := aead.Open(nil, nil, sealed[:aes.BlockSize-1], ad); err == nil {
+		t.Fatal("Open accepted a ciphertext shorter than the synthetic IV")
+	}
+}
@@ -0,0 +1,400 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/google/uuid"
+)
+
+// Stream layout, all integers big endian:
+//
+//	magic              2 bytes  "VS"
+//	format version     1 byte
+//	algorithm          1 byte
+//	key id             16 bytes  UUID of the master key version
+//	key version        4 bytes
+//	segment size       4 bytes   plaintext bytes per segment
+//	wrapped dek len    2 bytes
+//	wrapped dek        n bytes   master key nonce followed by the sealed data key
+//	nonce prefix len   1 byte
+//	nonce prefix       n bytes
+//	segments           remaining bytes
+//
+// Segments follow the STREAM construction (Hoang, Reyhanitabar, Rogaway, Vizár). Every
+// segment is sealed under the data key with the nonce prefix || 4-byte segment counter
+// || 1-byte final flag, and the header as AAD. Every segment but the last holds exactly
+// segment size bytes of plaintext, the last one holds 0 to segment size bytes and is
+// the only one sealed with the final flag, so reordered, dropped or truncated segments
+// fail to open. The encryption context is bound to the data key wrap.
+const (
+	streamMagic0 = 'V'
+	streamMagic1 = 'S'
+
+	StreamFormatV1 byte = 1
+
+	// StreamSegmentSize is the plaintext size of the segments NewEncryptWriter seals.
+	StreamSegmentSize = 64 * 1024
+	// maxStreamSegmentSize bounds the segment buffer a stream header can make a reader
+	// allocate before the header is authenticated.
+	maxStreamSegmentSize = 1024 * 1024
+
+	streamHeaderSize = 2 + 1 + 1 + 16 + 4 + 4 + 2
+	// streamNonceSuffix is the counter and final flag appended to the nonce prefix.
+	streamNonceSuffix = 4 + 1
+)
+
+var ErrNotStream = errors.New("ciphertext is not an encrypted stream")
+
+// ErrStreamTruncated is returned when a stream ends before its final segment.
+var ErrStreamTruncated = errors.New("encrypted stream is truncated")
+
+// StreamHeader is the header of an encrypted stream, it names the master key version
+// its data key is wrapped under.
+type StreamHeader struct {
+	Algorithm      AlgorithmID
+	KeyID          uuid.UUID
+	KeyVersion     uint32
+	SegmentSize    uint32
+	WrappedDataKey []byte
+	NoncePrefix    []byte
+
+	// raw is the encoded header, the AAD of every segment.
+	raw []byte
+}
+
+func (h *StreamHeader) MarshalBinary() ([]byte, error) {
+	if len(h.WrappedDataKey) > 0xFFFF {
+		return nil, errors.New("wrapped data key is too long")
+	}
+	if len(h.NoncePrefix) > 0xFF {
+		return nil, errors.New("nonce prefix is too long")
+	}
+
+	buf := make([]byte, 0, streamHeaderSize+len(h.WrappedDataKey)+1+len(h.NoncePrefix))
+	buf = append(buf, streamMagic0, streamMagic1, StreamFormatV1, byte(h.Algorithm))
+	buf = append(buf, h.KeyID[:]...)
+	buf = binary.BigEndian.AppendUint32(buf, h.KeyVersion)
+	buf = binary.BigEndian.AppendUint32(buf, h.SegmentSize)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(h.WrappedDataKey)))
+	buf = append(buf, h.WrappedDataKey...)
+	buf = append(buf, byte(len(h.NoncePrefix)))
+	buf = append(buf, h.NoncePrefix...)
+	return buf, nil
+}
+
+// ReadStreamHeader reads the header of an encrypted stream from src. It reads exactly
+// the header, src is left at the first segment for NewDecryptReader.
+func ReadStreamHeader(src io.Reader) (*StreamHeader, error) {
+	raw := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(src, raw); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNotStream
+		}
+		return nil, err
+	}
+	if raw[0] != streamMagic0 || raw[1] != streamMagic1 || raw[2] != StreamFormatV1 {
+		return nil, ErrNotStream
+	}
+
+	h := StreamHeader{Algorithm: AlgorithmID(raw[3])}
+	copy(h.KeyID[:], raw[4:20])
+	h.KeyVersion = binary.BigEndian.Uint32(raw[20:24])
+	h.SegmentSize = binary.BigEndian.Uint32(raw[24:28])
+	if h.SegmentSize == 0 || h.SegmentSize > maxStreamSegmentSize {
+		return nil, fmt.Errorf("stream segment size %d is out of range", h.SegmentSize)
+	}
+	if _, err := SuiteByID(h.Algorithm); err != nil {
+		return nil, err
+	}
+
+	// The wrapped data key is followed by the length of the nonce prefix.
+	wrappedLen := int(binary.BigEndian.Uint16(raw[28:30]))
+	rest := make([]byte, wrappedLen+1)
+	if _, err := io.ReadFull(src, rest); err != nil {
+		return nil, ErrStreamTruncated
+	}
+	h.WrappedDataKey = rest[:wrappedLen]
+	h.NoncePrefix = make([]byte, rest[wrappedLen])
+	if _, err := io.ReadFull(src, h.NoncePrefix); err != nil {
+		return nil, ErrStreamTruncated
+	}
+
+	h.raw = append(append(raw, rest...), h.NoncePrefix...)
+	return &h, nil
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it with a fresh
+// data key and writes the encrypted stream to dst. Nothing is written to dst before the
+// first segment is sealed, the header goes out with it. Close seals the final segment,
+// it does not close dst, and the stream is truncated unless Close returns nil. Memory
+// use is one segment whatever the length of the stream.
+func NewEncryptWriter(dst io.Writer, encryptionContext EncryptionContext, masterKey *MasterKey) (io.WriteCloser, error) {
+	dataKey, err := newDataKey()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+
+	wrappedDataKey, err := wrapDataKey(dataKey, dataKeyForStream, encryptionContext.Canonical(), masterKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := masterKey.suite.New(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	noncePrefix := make([]byte, aead.NonceSize()-streamNonceSuffix)
+	if _, err := io.ReadFull(rand.Reader, noncePrefix); err != nil {
+		return nil, err
+	}
+	header := StreamHeader{
+		Algorithm:      masterKey.suite.ID,
+		KeyID:          masterKey.KeyID,
+		KeyVersion:     uint32(masterKey.Version),
+		SegmentSize:    StreamSegmentSize,
+		WrappedDataKey: wrappedDataKey,
+		NoncePrefix:    noncePrefix,
+	}
+	raw, err := header.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		dst:    dst,
+		header: raw,
+		seg:    newStreamSegmenter(aead, noncePrefix, raw),
+		plain:  make([]byte, 0, StreamSegmentSize),
+		out:    make([]byte, 0, StreamSegmentSize+aead.Overhead()),
+	}, nil
+}
+
+// NewDecryptReader returns a reader of the plaintext of the stream whose header was read
+// from src by ReadStreamHeader. Every segment is authenticated before any of its
+// plaintext is returned, but a stream is only complete once Read returns io.EOF. A stream
+// cut at a segment boundary fails with ErrStreamTruncated where it was cut, one cut
+// inside a segment fails to authenticate that segment like any other tampering.
+func NewDecryptReader(src io.Reader, header *StreamHeader, encryptionContext EncryptionContext, masterKey *MasterKey) (io.Reader, error) {
+	envelope := &Envelope{
+		Algorithm:      header.Algorithm,
+		KeyID:          header.KeyID,
+		KeyVersion:     header.KeyVersion,
+		WrappedDataKey: header.WrappedDataKey,
+	}
+	dataKey, err := unwrapDataKey(envelope, dataKeyForStream, encryptionContext.Canonical(), masterKey)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+
+	aead, err := masterKey.suite.New(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(header.NoncePrefix)+streamNonceSuffix != aead.NonceSize() {
+		return nil, errors.New("stream nonce prefix has an invalid length")
+	}
+
+	segmentSize := int(header.SegmentSize) + aead.Overhead()
+	return &decryptReader{
+		src: bufio.NewReader(src),
+		seg: newStreamSegmenter(aead, header.NoncePrefix, header.raw),
+		in:  make([]byte, segmentSize),
+	}, nil
+}
+
+// streamSegmenter seals and opens the segments of one stream in order.
+type streamSegmenter struct {
+	aead    cipher.AEAD
+	nonce   []byte
+	aad     []byte
+	counter uint64
+	done    bool
+}
+
+func newStreamSegmenter(aead cipher.AEAD, noncePrefix, header []byte) *streamSegmenter {
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, noncePrefix)
+	return &streamSegmenter{aead: aead, nonce: nonce, aad: header}
+}
+
+// next sets the nonce of the next segment.
+func (s *streamSegmenter) next(final bool) error {
+	if s.done {
+		return errors.New("encrypted stream has data after its final segment")
+	}
+	if s.counter > math.MaxUint32 {
+		return errors.New("encrypted stream has too many segments")
+	}
+	suffix := s.nonce[len(s.nonce)-streamNonceSuffix:]
+	binary.BigEndian.PutUint32(suffix, uint32(s.counter))
+	suffix[4] = 0
+	if final {
+		suffix[4] = 1
+		s.done = true
+	}
+	s.counter++
+	return nil
+}
+
+func (s *streamSegmenter) seal(dst, plaintext []byte, final bool) ([]byte, error) {
+	if err := s.next(final); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(dst, s.nonce, plaintext, s.aad), nil
+}
+
+// sealedNonFinal reports whether the segment last opened as final, and refused, was
+// sealed as a non-final one, that is whether the stream was cut right after it.
+func (s *streamSegmenter) sealedNonFinal(ciphertext []byte) bool {
+	nonce := bytes.Clone(s.nonce)
+	nonce[len(nonce)-1] = 0
+	_, err := s.aead.Open(nil, nonce, ciphertext, s.aad)
+	return err == nil
+}
+
+func (s *streamSegmenter) open(dst, ciphertext []byte, final bool) ([]byte, error) {
+	segment := s.counter
+	if err := s.next(final); err != nil {
+		return nil, err
+	}
+	plaintext, err := s.aead.Open(dst, s.nonce, ciphertext, s.aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt stream segment %d: %w", segment, err)
+	}
+	return plaintext, nil
+}
+
+var errWriterClosed = errors.New("encrypt writer is closed")
+
+type encryptWriter struct {
+	dst io.Writer
+	// header is written with the first segment.
+	header []byte
+	seg    *streamSegmenter
+	plain  []byte
+	out    []byte
+	err    error
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.err != nil {
+			return written, w.err
+		}
+		// A full segment is only sealed once more data arrives, the final segment is
+		// sealed by Close and may be full as well.
+		if len(w.plain) == cap(w.plain) {
+			w.err = w.flush(false)
+			continue
+		}
+		n := copy(w.plain[len(w.plain):cap(w.plain)], p)
+		w.plain = w.plain[:len(w.plain)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, w.err
+}
+
+func (w *encryptWriter) Close() error {
+	if w.err == errWriterClosed {
+		return nil
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flush(true); err != nil {
+		w.err = err
+		return err
+	}
+	w.err = errWriterClosed
+	return nil
+}
+
+func (w *encryptWriter) flush(final bool) error {
+	out, err := w.seg.seal(w.out[:0], w.plain, final)
+	if err != nil {
+		return err
+	}
+	w.plain = w.plain[:0]
+	if w.header != nil {
+		if _, err := w.dst.Write(w.header); err != nil {
+			return err
+		}
+		w.header = nil
+	}
+	_, err = w.dst.Write(out)
+	return err
+}
+
+type decryptReader struct {
+	src   *bufio.Reader
+	seg   *streamSegmenter
+	in    []byte
+	plain []byte
+	err   error
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.nextSegment()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// nextSegment reads and opens one segment. A segment is final when it is short or
+// nothing follows it. It returns io.EOF once the final segment has been opened.
+func (r *decryptReader) nextSegment() error {
+	if r.seg.done {
+		return io.EOF
+	}
+	n, err := io.ReadFull(r.src, r.in)
+	final := false
+	switch {
+	case errors.Is(err, io.EOF):
+		return ErrStreamTruncated
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		final = true
+	case err != nil:
+		return err
+	default:
+		if _, err := r.src.Peek(1); errors.Is(err, io.EOF) {
+			final = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// A full segment with nothing after it is either the final one of a stream whose
+	// length is a multiple of the segment size or one a truncated stream ends with. It is
+	// kept, opening in place clears it on failure.
+	var full []byte
+	if final && n == len(r.in) {
+		full = bytes.Clone(r.in)
+	}
+
+	// Plaintext is opened in place, it never outgrows the ciphertext.
+	r.plain, err = r.seg.open(r.in[:0], r.in[:n], final)
+	if err != nil {
+		if full != nil && r.seg.sealedNonFinal(full) {
+			return ErrStreamTruncated
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func encryptStream(t *testing.T, plaintext []byte, encryptionContext EncryptionContext, masterKey *MasterKey) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, encryptionContext, masterKey)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter: %v", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decryptStream(stream []byte, encryptionContext EncryptionContext, masterKey *MasterKey) ([]byte, error) {
+	src := bytes.NewReader(stream)
+	header, err := ReadStreamHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewDecryptReader(src, header, encryptionContext, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// streamSegments returns the length of the header and of a sealed full segment.
+func streamSegments(t *testing.T, stream []byte) (int, int) {
+	t.Helper()
+	header, err := ReadStreamHeader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("ReadStreamHeader: %v", err)
+	}
+	return len(header.raw), int(header.SegmentSize) + 16
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	masterKey := newTestMasterKey(t, AlgorithmNameAES256GCM)
+	encryptionContext := EncryptionContext{"tenant": "a"}
+
+	for _, size := range []int{0, 1, StreamSegmentSize - 1, StreamSegmentSize, StreamSegmentSize + 1, 3 * StreamSegmentSize} {
+		plaintext := make([]byte, size)
+		if _, err := rand.Read(plaintext); err != nil {
+			t.Fatal(err)
+		}
+		stream := encryptStream(t, plaintext, encryptionContext, masterKey)
+
+		decrypted, err := decryptStream(stream, encryptionContext, masterKey)
+		if err != nil {
+			t.Fatalf("size %d: decrypt: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("size %d: decrypted plaintext differs", size)
+		}
+
+		if _, err := decryptStream(stream, EncryptionContext{"tenant": "b"}, masterKey); err == nil {
+			t.Fatalf("size %d: decrypted under another encryption context", size)
+		}
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	masterKey := newTestMasterKey(t, AlgorithmNameAES256GCM)
+	stream := encryptStream(t, make([]byte, 2*StreamSegmentSize+100), nil, masterKey)
+	headerSize, segmentSize := streamSegments(t, stream)
+
+	tests := []struct {
+		name   string
+		length int
+	}{
+		{"no segments", headerSize},
+		{"after first segment", headerSize + segmentSize},
+		{"after second segment", headerSize + 2*segmentSize},
+	}
+	for _, tt := range tests {
+		_, err := decryptStream(stream[:tt.length], nil, masterKey)
+		if !errors.Is(err, ErrStreamTruncated) {
+			t.Errorf("%s: got %v, want ErrStreamTruncated", tt.name, err)
+		}
+	}
+
+	// A cut inside a segment cannot be told apart from tampering.
+	_, err := decryptStream(stream[:headerSize+segmentSize+10], nil, masterKey)
+	if err == nil || errors.Is(err, ErrStreamTruncated) {
+		t.Errorf("cut inside a segment: got %v, want an authentication error", err)
+	}
+}
+
+func TestStreamTampered(t *testing.T) {
+	masterKey := newTestMasterKey(t, AlgorithmNameAES256GCM)
+	stream := encryptStream(t, make([]byte, 3*StreamSegmentSize+100), nil, masterKey)
+	headerSize, segmentSize := streamSegments(t, stream)
+	segment := func(s []byte, i int) []byte {
+		return s[headerSize+i*segmentSize : headerSize+(i+1)*segmentSize]
+	}
+
+	reordered := bytes.Clone(stream)
+	copy(segment(reordered, 0), segment(stream, 1))
+	copy(segment(reordered, 1), segment(stream, 0))
+
+	dropped := bytes.Clone(stream[:headerSize+segmentSize])
+	dropped = append(dropped, stream[headerSize+2*segmentSize:]...)
+
+	flipped := bytes.Clone(stream)
+	flipped[headerSize+segmentSize+1] ^= 1
+
+	extended := append(bytes.Clone(stream), segment(stream, 0)...)
+
+	tests := []struct {
+		name   string
+		stream []byte
+	}{
+		{"reordered", reordered},
+		{"dropped", dropped},
+		{"flipped", flipped},
+		{"extended", extended},
+	}
+	for _, tt := range tests {
+		if _, err := decryptStream(tt.stream, nil, masterKey); err == nil {
+			t.Errorf("%s: stream decrypted", tt.name)
+		}
+	}
+}
+
+func TestStreamDataKeyIsNotExported(t *testing.T) {
+	masterKey := newTestMasterKey(t, DefaultAlgorithm)
+	encryptionContext := EncryptionContext{"tenant": "a"}
+	stream := encryptStream(t, []byte("backup"), encryptionContext, masterKey)
+
+	// The header carries everything a wrapped data key from GenerateDataKey does.
+	header, err := ReadStreamHeader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("ReadStreamHeader: %v", err)
+	}
+	envelope := &Envelope{
+		Algorithm:      header.Algorithm,
+		KeyID:          header.KeyID,
+		KeyVersion:     header.KeyVersion,
+		WrappedDataKey: header.WrappedDataKey,
+	}
+	if _, err := DecryptDataKey(envelope, encryptionContext, masterKey); err == nil {
+		t.Fatal("DecryptDataKey unwrapped the data key of a stream")
+	}
+}
@@ -0,0 +1,165 @@
+package crypto
+
+import (
+	gocrypto "crypto"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+
+	"github.com/valu/encrpytion/internal/model"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Algorithm names as stored on encryption_keys and accepted at key creation.
+const (
+	AlgorithmNameAES256GCM         = "AES256_GCM"
+	AlgorithmNameChaCha20Poly1305  = "CHACHA20_POLY1305"
+	AlgorithmNameXChaCha20Poly1305 = "XCHACHA20_POLY1305"
+	AlgorithmNameAES256SIV         = "AES256_SIV"
+	AlgorithmNameHMACSHA256        = "HMAC_SHA256"
+	AlgorithmNameHMACSHA512        = "HMAC_SHA512"
+	AlgorithmNameEd25519           = "ED25519"
+	AlgorithmNameECDSAP256SHA256   = "ECDSA_P256_SHA256"
+	AlgorithmNameRSAPSS2048SHA256  = "RSA_PSS_2048_SHA256"
+	AlgorithmNameRSAPSS3072SHA256  = "RSA_PSS_3072_SHA256"
+	AlgorithmNameRSAOAEP2048SHA256 = "RSA_OAEP_2048_SHA256"
+	AlgorithmNameRSAOAEP3072SHA256 = "RSA_OAEP_3072_SHA256"
+	AlgorithmNameHPKEX25519        = "HPKE_X25519_SHA256_AES256GCM"
+
+	DefaultAlgorithm = AlgorithmNameAES256GCM
+)
+
+// Suite is an algorithm that master keys can be created for. Encryption suites have an
+// AEAD and an ID that is written into every envelope. Suites for KeyPurposeEncrypt wrap
+// data keys and encrypt the payloads under them, those take 32-byte keys so data keys
+// have the same size whatever the suite. Deterministic suites take no nonce and encrypt
+// payloads under the master key directly. MAC suites have a hash for HMAC instead.
+// Asymmetric suites generate a private key, which is stored PKCS #8 encoded, instead
+// of KeySize random bytes.
+type Suite struct {
+	ID      AlgorithmID
+	Name    string
+	Purpose model.KeyPurpose
+	KeySize int
+	New     func(key []byte) (cipher.AEAD, error)
+	Hash    func() hash.Hash
+
+	GenerateKey func() (privateKey, error)
+	SignerOpts  gocrypto.SignerOpts
+	// JWKAlgorithm is the "alg" of the exported public key.
+	JWKAlgorithm string
+}
+
+var (
+	suitesByID   = make(map[AlgorithmID]*Suite)
+	suitesByName = make(map[string]*Suite)
+
+	// defaultAlgorithms is the algorithm of a new key whose request names only a purpose.
+	defaultAlgorithms = map[model.KeyPurpose]string{
+		model.KeyPurposeEncrypt:           AlgorithmNameAES256GCM,
+		model.KeyPurposeDeterministic:     AlgorithmNameAES256SIV,
+		model.KeyPurposeMAC:               AlgorithmNameHMACSHA256,
+		model.KeyPurposeSign:              AlgorithmNameEd25519,
+		model.KeyPurposeAsymmetricDecrypt: AlgorithmNameHPKEX25519,
+	}
+)
+
+func registerSuite(suite *Suite) {
+	if _, ok := suitesByName[suite.Name]; ok {
+		panic(fmt.Sprintf("crypto: suite %s registered twice", suite.Name))
+	}
+	suitesByName[suite.Name] = suite
+	if suite.ID == 0 {
+		// Not an envelope algorithm.
+		return
+	}
+	if _, ok := suitesByID[suite.ID]; ok {
+		panic(fmt.Sprintf("crypto: suite %d registered twice", suite.ID))
+	}
+	suitesByID[suite.ID] = suite
+}
+
+func init() {
+	registerSuite(&Suite{ID: AlgorithmAES256GCM, Name: AlgorithmNameAES256GCM,
+		Purpose: model.KeyPurposeEncrypt, KeySize: MasterKeySize, New: newGCM})
+	// ChaCha20-Poly1305 is fast in software, for clients on hardware without AES-NI.
+	registerSuite(&Suite{ID: AlgorithmChaCha20Poly1305, Name: AlgorithmNameChaCha20Poly1305,
+		Purpose: model.KeyPurposeEncrypt, KeySize: MasterKeySize, New: chacha20poly1305.New})
+	// XChaCha20-Poly1305 has 24-byte nonces, random nonces stay safe for high-volume keys.
+	registerSuite(&Suite{ID: AlgorithmXChaCha20Poly1305, Name: AlgorithmNameXChaCha20Poly1305,
+		Purpose: model.KeyPurposeEncrypt, KeySize: MasterKeySize, New: chacha20poly1305.NewX})
+	// AES-256-SIV splits its 64-byte key between S2V and CTR.
+	registerSuite(&Suite{ID: AlgorithmAES256SIV, Name: AlgorithmNameAES256SIV,
+		Purpose: model.KeyPurposeDeterministic, KeySize: 64, New: newSIV})
+	// HMAC keys are as long as the hash output, the minimum RFC 2104 recommends.
+	registerSuite(&Suite{Name: AlgorithmNameHMACSHA256, Purpose: model.KeyPurposeMAC, KeySize: 32, Hash: sha256.New})
+	registerSuite(&Suite{Name: AlgorithmNameHMACSHA512, Purpose: model.KeyPurposeMAC, KeySize: 64, Hash: sha512.New})
+
+	// Ed25519 signs the message itself, the other signing suites sign its digest.
+	registerSuite(&Suite{Name: AlgorithmNameEd25519, Purpose: model.KeyPurposeSign,
+		GenerateKey: generateEd25519, SignerOpts: gocrypto.Hash(0), JWKAlgorithm: "EdDSA"})
+	registerSuite(&Suite{Name: AlgorithmNameECDSAP256SHA256, Purpose: model.KeyPurposeSign,
+		GenerateKey: generateECDSA(elliptic.P256()), SignerOpts: gocrypto.SHA256, JWKAlgorithm: "ES256"})
+	pss := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: gocrypto.SHA256}
+	registerSuite(&Suite{Name: AlgorithmNameRSAPSS2048SHA256, Purpose: model.KeyPurposeSign,
+		GenerateKey: generateRSA(2048), SignerOpts: pss, JWKAlgorithm: "PS256"})
+	registerSuite(&Suite{Name: AlgorithmNameRSAPSS3072SHA256, Purpose: model.KeyPurposeSign,
+		GenerateKey: generateRSA(3072), SignerOpts: pss, JWKAlgorithm: "PS256"})
+
+	// RSA-OAEP uses SHA-256 for both the label hash and MGF1. HPKE has no JWK "alg".
+	registerSuite(&Suite{Name: AlgorithmNameRSAOAEP2048SHA256, Purpose: model.KeyPurposeAsymmetricDecrypt,
+		GenerateKey: generateRSA(2048), JWKAlgorithm: "RSA-OAEP-256"})
+	registerSuite(&Suite{Name: AlgorithmNameRSAOAEP3072SHA256, Purpose: model.KeyPurposeAsymmetricDecrypt,
+		GenerateKey: generateRSA(3072), JWKAlgorithm: "RSA-OAEP-256"})
+	registerSuite(&Suite{Name: AlgorithmNameHPKEX25519, Purpose: model.KeyPurposeAsymmetricDecrypt,
+		GenerateKey: generateX25519})
+}
+
+// SuiteByID returns the suite an envelope names.
+func SuiteByID(id AlgorithmID) (*Suite, error) {
+	suite, ok := suitesByID[id]
+	if !ok {
+		return nil, fmt.Errorf("unsupported envelope algorithm %d", id)
+	}
+	return suite, nil
+}
+
+// SuiteByName returns the suite of a key. Keys stored before algorithms were recorded
+// have no name and use AES-256-GCM.
+func SuiteByName(name string) (*Suite, error) {
+	if name == "" {
+		name = DefaultAlgorithm
+	}
+	suite, ok := suitesByName[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", name)
+	}
+	return suite, nil
+}
+
+// KeyAlgorithm resolves the algorithm of a new key with the given purpose. An empty
+// purpose is KeyPurposeEncrypt and an empty algorithm selects the purpose's default.
+func KeyAlgorithm(purpose model.KeyPurpose, algorithm string) (string, error) {
+	if purpose == "" {
+		purpose = model.KeyPurposeEncrypt
+	}
+	defaultAlgorithm, ok := defaultAlgorithms[purpose]
+	if !ok {
+		return "", fmt.Errorf("unsupported key purpose %q", purpose)
+	}
+	if algorithm == "" {
+		return defaultAlgorithm, nil
+	}
+	suite, err := SuiteByName(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if suite.Purpose != purpose {
+		return "", fmt.Errorf("algorithm %s is for %s keys, not %s", suite.Name, suite.Purpose, purpose)
+	}
+	return suite.Name, nil
+}
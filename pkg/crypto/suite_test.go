@@ -0,0 +1,102 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testSuites = []string{AlgorithmNameAES256GCM, AlgorithmNameChaCha20Poly1305, AlgorithmNameXChaCha20Poly1305}
+
+func TestSuiteRoundTrip(t *testing.T) {
+	encryptionContext := EncryptionContext{"tenant": "a"}
+	for _, algorithm := range testSuites {
+		t.Run(algorithm, func(t *testing.T) {
+			masterKey := newTestMasterKey(t, algorithm)
+
+			ciphertext, err := EncryptMessage([]byte("attack at dawn"), encryptionContext, masterKey)
+			if err != nil {
+				t.Fatalf("EncryptMessage: %v", err)
+			}
+			envelope, err := ParseEnvelope(ciphertext)
+			if err != nil {
+				t.Fatalf("ParseEnvelope: %v", err)
+			}
+			if envelope.Algorithm != masterKey.Suite().ID {
+				t.Fatalf("envelope algorithm is %d, want %d", envelope.Algorithm, masterKey.Suite().ID)
+			}
+			message, err := DecryptMessage(envelope, encryptionContext, masterKey)
+			if err != nil {
+				t.Fatalf("DecryptMessage: %v", err)
+			}
+			if string(message) != "attack at dawn" {
+				t.Fatalf("DecryptMessage returned %q", message)
+			}
+			if _, err := DecryptMessage(envelope, EncryptionContext{"tenant": "b"}, masterKey); err == nil {
+				t.Fatal("DecryptMessage succeeded with a different encryption context")
+			}
+
+			dataKey, blob, err := GenerateDataKey(encryptionContext, masterKey)
+			if err != nil {
+				t.Fatalf("GenerateDataKey: %v", err)
+			}
+			if envelope, err = ParseEnvelope(blob); err != nil {
+				t.Fatalf("ParseEnvelope: %v", err)
+			}
+			got, err := DecryptDataKey(envelope, encryptionContext, masterKey)
+			if err != nil || !bytes.Equal(got, dataKey) {
+				t.Fatalf("DecryptDataKey returned %x, %v, want %x", got, err, dataKey)
+			}
+		})
+	}
+}
+
+func TestEnvelopeAlgorithmMustMatchKey(t *testing.T) {
+	masterKey := newTestMasterKey(t, AlgorithmNameChaCha20Poly1305)
+	ciphertext, err := EncryptMessage([]byte("secret"), nil, masterKey)
+	if err != nil {
+		t.Fatalf("EncryptMessage: %v", err)
+	}
+	envelope, err := ParseEnvelope(ciphertext)
+	if err != nil {
+		t.Fatalf("ParseEnvelope: %v", err)
+	}
+
+	// Both ChaCha20 suites take the same key, only the envelope header tells them apart.
+	envelope.Algorithm = AlgorithmXChaCha20Poly1305
+	if _, err := DecryptMessage(envelope, nil, masterKey); err == nil {
+		t.Fatal("DecryptMessage accepted an envelope naming another suite than the key's")
+	}
+}
+
+func TestRewrapEnvelopeKeepsSuite(t *testing.T) {
+	encryptionContext := EncryptionContext{"tenant": "a"}
+	oldKey := newTestMasterKey(t, AlgorithmNameXChaCha20Poly1305)
+	ciphertext, err := EncryptMessage([]byte("secret"), encryptionContext, oldKey)
+	if err != nil {
+		t.Fatalf("EncryptMessage: %v", err)
+	}
+	envelope, err := ParseEnvelope(ciphertext)
+	if err != nil {
+		t.Fatalf("ParseEnvelope: %v", err)
+	}
+
+	if _, err := RewrapEnvelope(envelope, encryptionContext, oldKey, newTestMasterKey(t, AlgorithmNameAES256GCM)); err == nil {
+		t.Fatal("RewrapEnvelope moved an envelope to a key of another suite")
+	}
+
+	newKey := newTestMasterKey(t, AlgorithmNameXChaCha20Poly1305)
+	rewrapped, err := RewrapEnvelope(envelope, encryptionContext, oldKey, newKey)
+	if err != nil {
+		t.Fatalf("RewrapEnvelope: %v", err)
+	}
+	if envelope, err = ParseEnvelope(rewrapped); err != nil {
+		t.Fatalf("ParseEnvelope: %v", err)
+	}
+	if envelope.KeyID != newKey.KeyID {
+		t.Fatalf("rewrapped envelope names key %s, want %s", envelope.KeyID, newKey.KeyID)
+	}
+	message, err := DecryptMessage(envelope, encryptionContext, newKey)
+	if err != nil || string(message) != "secret" {
+		t.Fatalf("DecryptMessage after rewrap returned %q, %v", message, err)
+	}
+}
@@ -48,3 +48,22 @@ func MethodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 func BadRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
 	SendErrorResponse(w, r, http.StatusBadRequest, err.Error())
 }
+
+func ConflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	SendErrorResponse(w, r, http.StatusConflict, err.Error())
+}
+
+func SealedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the keystore is sealed, submit key shares to /v1/sys/unseal"
+	SendErrorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+func UnauthorizedResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "a valid API token must be provided as a bearer token"
+	SendErrorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func ForbiddenResponse(w http.ResponseWriter, r *http.Request, err error) {
+	SendErrorResponse(w, r, http.StatusForbidden, err.Error())
+}
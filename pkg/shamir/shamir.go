@@ -0,0 +1,177 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8).
+//
+// Every byte of the secret is shared with its own random polynomial of degree
+// threshold-1. A share is the evaluation of all polynomials at one x coordinate,
+// with that x coordinate appended as the last byte.
+package shamir
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ShareOverhead is the number of bytes a share adds on top of the secret length.
+const ShareOverhead = 1
+
+// Split divides secret into parts shares, any threshold of which reconstruct it.
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	if parts < threshold {
+		return nil, errors.New("parts cannot be less than threshold")
+	}
+	if parts > 255 {
+		return nil, errors.New("parts cannot exceed 255")
+	}
+	if threshold < 2 {
+		return nil, errors.New("threshold must be at least 2")
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("cannot split an empty secret")
+	}
+
+	// Pick distinct, non-zero x coordinates in random order.
+	xCoordinates, err := randomCoordinates(parts)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+ShareOverhead)
+		shares[i][len(secret)] = xCoordinates[i]
+	}
+
+	coefficients := make([]byte, threshold)
+	for idx, secretByte := range secret {
+		// The constant term is the secret byte, the rest are random.
+		coefficients[0] = secretByte
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i, x := range xCoordinates {
+			shares[i][idx] = evaluate(coefficients, x)
+		}
+	}
+	for i := range coefficients {
+		coefficients[i] = 0
+	}
+
+	return shares, nil
+}
+
+// Combine reconstructs the secret from at least threshold shares.
+// Combining fewer shares than the threshold yields a wrong secret, not an error,
+// so callers have to verify the result.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+	shareLen := len(shares[0])
+	if shareLen <= ShareOverhead {
+		return nil, errors.New("shares are too short")
+	}
+	xSamples := make([]byte, len(shares))
+	seen := make(map[byte]bool, len(shares))
+	for i, share := range shares {
+		if len(share) != shareLen {
+			return nil, errors.New("all shares must be the same length")
+		}
+		x := share[shareLen-1]
+		if x == 0 {
+			return nil, errors.New("share has an invalid x coordinate")
+		}
+		if seen[x] {
+			return nil, fmt.Errorf("duplicate share detected")
+		}
+		seen[x] = true
+		xSamples[i] = x
+	}
+
+	secret := make([]byte, shareLen-ShareOverhead)
+	ySamples := make([]byte, len(shares))
+	for idx := range secret {
+		for i, share := range shares {
+			ySamples[i] = share[idx]
+		}
+		secret[idx] = interpolateAtZero(xSamples, ySamples)
+	}
+	return secret, nil
+}
+
+func randomCoordinates(parts int) ([]byte, error) {
+	var perm [255]byte
+	for i := range perm {
+		perm[i] = byte(i + 1)
+	}
+	// Fisher-Yates shuffle driven by crypto/rand.
+	var buf [1]byte
+	for i := len(perm) - 1; i > 0; i-- {
+		if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+			return nil, err
+		}
+		j := int(buf[0]) % (i + 1)
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+	return perm[:parts], nil
+}
+
+// evaluate computes the polynomial at x using Horner's method.
+func evaluate(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = add(mul(result, x), coefficients[i])
+	}
+	return result
+}
+
+// interpolateAtZero evaluates the Lagrange polynomial through the samples at x = 0.
+func interpolateAtZero(xSamples, ySamples []byte) byte {
+	var result byte
+	for i := range xSamples {
+		basis := byte(1)
+		for j := range xSamples {
+			if i == j {
+				continue
+			}
+			num := xSamples[j]
+			denom := add(xSamples[i], xSamples[j])
+			basis = mul(basis, div(num, denom))
+		}
+		result = add(result, mul(ySamples[i], basis))
+	}
+	return result
+}
+
+func add(a, b byte) byte {
+	return a ^ b
+}
+
+// mul multiplies in GF(2^8) with the AES reduction polynomial without branching on secret data.
+func mul(a, b byte) byte {
+	var result byte
+	for i := 0; i < 8; i++ {
+		result ^= byte(subtle.ConstantTimeByteEq(b&1, 1)) * a
+		carry := a >> 7
+		a <<= 1
+		a ^= 0x1b * carry
+		b >>= 1
+	}
+	return result
+}
+
+// inverse computes a^254, which is a^-1 in GF(2^8).
+func inverse(a byte) byte {
+	b := mul(a, a)
+	result := b
+	for i := 0; i < 6; i++ {
+		b = mul(b, b)
+		result = mul(result, b)
+	}
+	return result
+}
+
+func div(a, b byte) byte {
+	return mul(a, inverse(b))
+}
@@ -0,0 +1,135 @@
+package shamir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestFieldArithmetic(t *testing.T) {
+	// FIPS 197 section 4.2 and the multiplicative inverse of 0x53.
+	if got := mul(0x57, 0x83); got != 0xc1 {
+		t.Errorf("mul(0x57, 0x83) = %#x, want 0xc1", got)
+	}
+	if got := mul(0x57, 0x13); got != 0xfe {
+		t.Errorf("mul(0x57, 0x13) = %#x, want 0xfe", got)
+	}
+	if got := inverse(0x53); got != 0xca {
+		t.Errorf("inverse(0x53) = %#x, want 0xca", got)
+	}
+	for a := 1; a < 256; a++ {
+		if got := mul(byte(a), inverse(byte(a))); got != 1 {
+			t.Fatalf("%#x * inverse(%#x) = %#x, want 1", a, a, got)
+		}
+	}
+}
+
+func TestCombineKnownShares(t *testing.T) {
+	// f(x) = 0x42 + 0x11x + 0x05x^2 sampled at x = 1, 2 and 3.
+	coefficients := []byte{0x42, 0x11, 0x05}
+	var shares [][]byte
+	for _, x := range []byte{1, 2, 3} {
+		shares = append(shares, []byte{evaluate(coefficients, x), x})
+	}
+	if shares[0][0] != 0x42^0x11^0x05 {
+		t.Fatalf("f(1) = %#x, want %#x", shares[0][0], 0x42^0x11^0x05)
+	}
+
+	secret, err := Combine(shares)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret, []byte{0x42}) {
+		t.Fatalf("Combine = %x, want 42", secret)
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct{ parts, threshold int }{{2, 2}, {3, 2}, {5, 3}, {7, 7}} {
+		shares, err := Split(secret, tt.parts, tt.threshold)
+		if err != nil {
+			t.Fatalf("Split(%d, %d): %v", tt.parts, tt.threshold, err)
+		}
+		if len(shares) != tt.parts {
+			t.Fatalf("Split(%d, %d) returned %d shares", tt.parts, tt.threshold, len(shares))
+		}
+
+		// Every subset of at least threshold shares reconstructs the secret, every
+		// smaller one yields a different value.
+		for _, subset := range subsets(tt.parts) {
+			if len(subset) < 2 {
+				continue
+			}
+			picked := make([][]byte, len(subset))
+			for i, idx := range subset {
+				picked[i] = shares[idx]
+			}
+			got, err := Combine(picked)
+			if err != nil {
+				t.Fatalf("%d of %d: Combine(%v): %v", tt.threshold, tt.parts, subset, err)
+			}
+			enough := len(subset) >= tt.threshold
+			if bytes.Equal(got, secret) != enough {
+				t.Errorf("%d of %d: Combine(%v) with enough shares %v, got %x, want %x", tt.threshold, tt.parts, subset, enough, got, secret)
+			}
+		}
+	}
+}
+
+// subsets returns every subset of the indexes 0 to n-1.
+func subsets(n int) [][]int {
+	var all [][]int
+	for mask := 1; mask < 1<<n; mask++ {
+		var subset []int
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) != 0 {
+				subset = append(subset, i)
+			}
+		}
+		all = append(all, subset)
+	}
+	return all
+}
+
+func TestSplitInvalid(t *testing.T) {
+	secret := []byte("secret")
+	for _, tt := range []struct {
+		name             string
+		secret           []byte
+		parts, threshold int
+	}{
+		{"fewer parts than threshold", secret, 2, 3},
+		{"too many parts", secret, 256, 3},
+		{"threshold of one", secret, 3, 1},
+		{"empty secret", nil, 3, 2},
+	} {
+		if _, err := Split(tt.secret, tt.parts, tt.threshold); err == nil {
+			t.Errorf("%s: Split succeeded", tt.name)
+		}
+	}
+}
+
+func TestCombineInvalid(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		shares [][]byte
+	}{
+		{"one share", shares[:1]},
+		{"duplicate shares", [][]byte{shares[0], shares[0]}},
+		{"different lengths", [][]byte{shares[0], shares[1][1:]}},
+		{"zero x coordinate", [][]byte{shares[0], append(bytes.Clone(shares[1][:len(shares[1])-1]), 0)}},
+	} {
+		if _, err := Combine(tt.shares); err == nil {
+			t.Errorf("%s: Combine succeeded", tt.name)
+		}
+	}
+}